@@ -1,99 +1,30 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 )
 
 type bindJsonStats struct {
-	JsonStatsVersion string    `json:"json-stats-version"`
-	BootTime         time.Time `json:"boot-time"`
-	ConfigTime       time.Time `json:"config-time"`
-	CurrentTime      time.Time `json:"current-time"`
-	Version          string    `json:"version"`
-	OpCodes          struct {
-		Query      int `json:"QUERY"`
-		IQuery     int `json:"IQUERY"`
-		Status     int `json:"STATUS"`
-		Reserved3  int `json:"RESERVED3"`
-		Notify     int `json:"NOTIFY"`
-		Update     int `json:"UPDATE"`
-		Reserved6  int `json:"RESERVED6"`
-		Reserved7  int `json:"RESERVED7"`
-		Reserved8  int `json:"RESERVED8"`
-		Reserved9  int `json:"RESERVED9"`
-		Reserved10 int `json:"RESERVED10"`
-		Reserved11 int `json:"RESERVED11"`
-		Reserved12 int `json:"RESERVED12"`
-		Reserved13 int `json:"RESERVED13"`
-		Reserved14 int `json:"RESERVED14"`
-		Reserved15 int `json:"RESERVED15"`
-	} `json:"opcodes"`
-	RCodes  RCode  `json:"rcodes"`
-	QTypes  QTypes `json:"qtypes"`
-	NSStats struct {
-		Requestv4        int `json:"Requestv4"`
-		Requestv6        int `json:"Requestv6"`
-		ReqEdns0         int `json:"ReqEdns0"`
-		ReqTCP           int `json:"ReqTCP"`
-		TCPConnHighWater int `json:"TCPConnHighWater"`
-		AuthQryRej       int `json:"AuthQryRej"`
-		RecQryRej        int `json:"RecQryRej"`
-		Response         int `json:"Response"`
-		TruncatedResp    int `json:"TruncatedResp"`
-		RespEDNS0        int `json:"RespEDNS0"`
-		QrySuccess       int `json:"QrySuccess"`
-		QryAuthAns       int `json:"QryAuthAns"`
-		QryNoauthAns     int `json:"QryNoauthAns"`
-		QryReferral      int `json:"QryReferral"`
-		QryNxrrset       int `json:"QryNxrrset"`
-		QryNXDOMAIN      int `json:"QryNXDOMAIN"`
-		QryFailure       int `json:"QryFailure"`
-		QryUDP           int `json:"QryUDP"`
-		QryTCP           int `json:"QryTCP"`
-		CookieIn         int `json:"CookieIn"`
-		CookieNew        int `json:"CookieNew"`
-		CookieMatch      int `json:"CookieMatch"`
-		ECSOpt           int `json:"ECSOpt"`
-	} `json:"nsstats"`
-	ZoneStats struct {
-		NotifyInv4 int `json:"NotifyInv4"`
-		SOAOutv4   int `json:"SOAOutv4"`
-		AXFRReqv4  int `json:"AXFRReqv4"`
-		IXFRReqv4  int `json:"IXFRReqv4"`
-		XfrSuccess int `json:"XfrSuccess"`
-	} `json:"zonestats"`
-	Views struct {
-		Default BindView `json:"_default"`
-		Bind    BindView `json:"_bind"`
-	} `json:"views"`
-	SocketStats struct {
-		UDP4Open    int `json:"UDP4Open"`
-		UDP6Open    int `json:"UDP6Open"`
-		TCP4Open    int `json:"TCP4Open"`
-		TCP6Open    int `json:"TCP6Open"`
-		RawOpen     int `json:"RawOpen"`
-		UDP4Close   int `json:"UDP4Close"`
-		UDP6Close   int `json:"UDP6Close"`
-		TCP4Close   int `json:"TCP4Close"`
-		TCP6Close   int `json:"TCP6Close"`
-		UDP6Conn    int `json:"UDP6Conn"`
-		TCP4Conn    int `json:"TCP4Conn"`
-		TCP6Conn    int `json:"TCP6Conn"`
-		TCP4Accept  int `json:"TCP4Accept"`
-		TCP6Accept  int `json:"TCP6Accept"`
-		TCP4RecvErr int `json:"TCP4RecvErr"`
-		UDP4Active  int `json:"UDP4Active"`
-		UDP6Active  int `json:"UDP6Active"`
-		TCP4Active  int `json:"TCP4Active"`
-		TCP6Active  int `json:"TCP6Active"`
-		RawActive   int `json:"RawActive"`
-	} `json:"socketstats"`
-	SocketMgr struct {
+	JsonStatsVersion string              `json:"json-stats-version"`
+	BootTime         time.Time           `json:"boot-time"`
+	ConfigTime       time.Time           `json:"config-time"`
+	CurrentTime      time.Time           `json:"current-time"`
+	Version          string              `json:"version"`
+	OpCodes          JsonCounters        `json:"opcodes"`
+	RCodes           JsonCounters        `json:"rcodes"`
+	QTypes           JsonCounters        `json:"qtypes"`
+	NSStats          JsonCounters        `json:"nsstats"`
+	ZoneStats        JsonCounters        `json:"zonestats"`
+	Views            map[string]BindView `json:"views"`
+	SocketStats      JsonCounters        `json:"sockstats"`
+	SocketMgr        struct {
 		Sockets []SocketMgrSocket `json:"sockets"`
 	} `json:"socketmgr"`
 	TaskMgr struct {
@@ -101,7 +32,7 @@ type bindJsonStats struct {
 		DefaultQuantum int            `json:"default-quantum"`
 		Tasks          []*TaskMgrTask `json:"tasks"`
 	} `json:"taskmgr"`
-	Memory struct {
+	Memory *struct {
 		TotalUse    int        `json:"TotalUse"`
 		InUse       int        `json:"InUse"`
 		Malloced    int        `json:"Malloced"`
@@ -113,489 +44,64 @@ type bindJsonStats struct {
 	Traffic Traffic `json:"traffic"`
 }
 
-type QTypes struct {
-	Others     int `json:"Others,omitempty"`
-	A          int `json:"A,omitempty"`
-	Ns         int `json:"NS,omitempty"`
-	Cname      int `json:"CNAME,omitempty"`
-	Soa        int `json:"SOA,omitempty"`
-	Ptr        int `json:"PTR,omitempty"`
-	Mx         int `json:"MX,omitempty"`
-	Txt        int `json:"TXT,omitempty"`
-	Afsdb      int `json:"AFSDB,omitempty"`
-	Aaaa       int `json:"AAAA,omitempty"`
-	Srv        int `json:"SRV,omitempty"`
-	Naptr      int `json:"NAPTR,omitempty"`
-	Dname      int `json:"DNAME,omitempty"`
-	Ds         int `json:"DS,omitempty"`
-	Rrsig      int `json:"RRSIG,omitempty"`
-	Dnskey     int `json:"DNSKEY,omitempty"`
-	Nsec3param int `json:"NSEC3PARAM,omitempty"`
-	Tlsa       int `json:"TLSA,omitempty"`
-	Cds        int `json:"CDS,omitempty"`
-	Cdnskey    int `json:"CDNSKEY,omitempty"`
-	Zonemd     int `json:"ZONEMD,omitempty"`
-	Svcb       int `json:"SVCB,omitempty"`
-	Https      int `json:"HTTPS,omitempty"`
-	Spf        int `json:"SPF,omitempty"`
-	Any        int `json:"ANY,omitempty"`
-}
-
-func (q *QTypes) toMetrics(metric_time time.Time) []*Metric {
-	metrics := make([]*Metric, 0)
-	metrics = append(metrics, &Metric{
-		Name:      "Others",
-		Value:     int64(q.Others),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "A",
-		Value:     int64(q.A),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "NS",
-		Value:     int64(q.Ns),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "CNAME",
-		Value:     int64(q.Cname),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "SOA",
-		Value:     int64(q.Soa),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "PTR",
-		Value:     int64(q.Ptr),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "MX",
-		Value:     int64(q.Mx),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "TXT",
-		Value:     int64(q.Txt),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "AFSDB",
-		Value:     int64(q.Afsdb),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "AAAA",
-		Value:     int64(q.Aaaa),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "SRV",
-		Value:     int64(q.Srv),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "NAPTR",
-		Value:     int64(q.Naptr),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "DNAME",
-		Value:     int64(q.Dname),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "DS",
-		Value:     int64(q.Ds),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "RRSIG",
-		Value:     int64(q.Rrsig),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "DNSKEY",
-		Value:     int64(q.Dnskey),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "NSEC3PARAM",
-		Value:     int64(q.Nsec3param),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "TLSA",
-		Value:     int64(q.Tlsa),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "CDS",
-		Value:     int64(q.Cds),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "CDNSKEY",
-		Value:     int64(q.Cdnskey),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "ZONEMD",
-		Value:     int64(q.Zonemd),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "SVCB",
-		Value:     int64(q.Svcb),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "HTTPS",
-		Value:     int64(q.Https),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "SPF",
-		Value:     int64(q.Spf),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "ANY",
-		Value:     int64(q.Any),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
+// JsonCounters holds a BIND counter group, such as the nsstats or the
+// resolver qtypes. The counters are kept as a map so that any counter BIND
+// adds in a new release is picked up without changing the plugin.
+type JsonCounters map[string]int64
+
+func (jc *JsonCounters) UnmarshalJSON(data []byte) error {
+	// Decode the numbers as they are written, a float64 would round counters
+	// above 2^53
+	var counters map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&counters); err != nil {
+		return err
+	}
 
-	return metrics
-}
+	*jc = make(JsonCounters, len(counters))
+	for name, value := range counters {
+		// Skip anything that is not a plain counter
+		number, ok := value.(json.Number)
+		if !ok {
+			continue
+		}
+		if counter, err := number.Int64(); err == nil {
+			(*jc)[name] = counter
+		}
+	}
 
-type RCode struct {
-	R17         int `json:"17,omitempty"`
-	R18         int `json:"18,omitempty"`
-	R19         int `json:"19,omitempty"`
-	R20         int `json:"20,omitempty"`
-	R21         int `json:"21,omitempty"`
-	R22         int `json:"22,omitempty"`
-	AuthQryRej  int `json:"AuthQryRej,omitempty"`
-	Badcookie   int `json:"BADCOOKIE,omitempty"`
-	Badvers     int `json:"BADVERS,omitempty"`
-	Formerr     int `json:"FORMERR,omitempty"`
-	Noerror     int `json:"NOERROR,omitempty"`
-	Notauth     int `json:"NOTAUTH,omitempty"`
-	Notimp      int `json:"NOTIMP,omitempty"`
-	Notzone     int `json:"NOTZONE,omitempty"`
-	Nxdomain    int `json:"NXDOMAIN,omitempty"`
-	Nxrrset     int `json:"NXRRSET,omitempty"`
-	QryAuthAns  int `json:"QryAuthAns,omitempty"`
-	QryNXDOMAIN int `json:"QryNXDOMAIN,omitempty"`
-	QryNxrrset  int `json:"QryNxrrset,omitempty"`
-	QrySuccess  int `json:"QrySuccess,omitempty"`
-	QryTCP      int `json:"QryTCP,omitempty"`
-	QryUDP      int `json:"QryUDP,omitempty"`
-	Refused     int `json:"REFUSED,omitempty"`
-	Reserved11  int `json:"RESERVED11,omitempty"`
-	Reserved12  int `json:"RESERVED12,omitempty"`
-	Reserved13  int `json:"RESERVED13,omitempty"`
-	Reserved14  int `json:"RESERVED14,omitempty"`
-	Reserved15  int `json:"RESERVED15,omitempty"`
-	RecQryRej   int `json:"RecQryRej,omitempty"`
-	Servfail    int `json:"SERVFAIL,omitempty"`
-	UpdateDone  int `json:"UpdateDone,omitempty"`
-	XfrRej      int `json:"XfrRej,omitempty"`
-	XfrReqDone  int `json:"XfrReqDone,omitempty"`
-	Yxdomain    int `json:"YXDOMAIN,omitempty"`
-	Yxrrset     int `json:"YXRRSET,omitempty"`
+	return nil
 }
 
-func (r *RCode) toMetrics(metric_time time.Time) []*Metric {
-	metrics := make([]*Metric, 0)
-	metrics = append(metrics, &Metric{
-		Name:      "17",
-		Value:     int64(r.R17),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "18",
-		Value:     int64(r.R18),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "19",
-		Value:     int64(r.R19),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "20",
-		Value:     int64(r.R20),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "21",
-		Value:     int64(r.R21),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "22",
-		Value:     int64(r.R22),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "AuthQryRej",
-		Value:     int64(r.AuthQryRej),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "Badcookie",
-		Value:     int64(r.Badcookie),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "Badvers",
-		Value:     int64(r.Badvers),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "Formerr",
-		Value:     int64(r.Formerr),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "Noerror",
-		Value:     int64(r.Noerror),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "Notauth",
-		Value:     int64(r.Notauth),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "Notimp",
-		Value:     int64(r.Notimp),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "Notzone",
-		Value:     int64(r.Notzone),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "Nxdomain",
-		Value:     int64(r.Nxdomain),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "Nxrrset",
-		Value:     int64(r.Nxrrset),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "QryAuthAns",
-		Value:     int64(r.QryAuthAns),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "QryNXDOMAIN",
-		Value:     int64(r.QryNXDOMAIN),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "QryNxrrset",
-		Value:     int64(r.QryNxrrset),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "QrySuccess",
-		Value:     int64(r.QrySuccess),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "QryTCP",
-		Value:     int64(r.QryTCP),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "QryUDP",
-		Value:     int64(r.QryUDP),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "Refused",
-		Value:     int64(r.Refused),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "Reserved11",
-		Value:     int64(r.Reserved11),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "Reserved12",
-		Value:     int64(r.Reserved12),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "Reserved13",
-		Value:     int64(r.Reserved13),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "Reserved14",
-		Value:     int64(r.Reserved14),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "Reserved15",
-		Value:     int64(r.Reserved15),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "RecQryRej",
-		Value:     int64(r.RecQryRej),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "Servfail",
-		Value:     int64(r.Servfail),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "UpdateDone",
-		Value:     int64(r.UpdateDone),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "XfrRej",
-		Value:     int64(r.XfrRej),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "XfrReqDone",
-		Value:     int64(r.XfrReqDone),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "Yxdomain",
-		Value:     int64(r.Yxdomain),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
-	metrics = append(metrics, &Metric{
-		Name:      "Yxrrset",
-		Value:     int64(r.Yxrrset),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{},
-	})
+func (jc JsonCounters) toMetrics(metric_time time.Time) []*Metric {
+	// Sort the counter names so the output order is stable
+	names := make([]string, 0, len(jc))
+	for name := range jc {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 
+	metrics := make([]*Metric, 0, len(jc))
+	for _, name := range names {
+		metrics = append(metrics, &Metric{
+			Name:      name,
+			Value:     jc[name],
+			Timestamp: metric_time,
+			Tags:      []*MetricTag{},
+		})
+	}
 	return metrics
 }
 
 type BindView struct {
 	Zones    []*ZoneView `json:"zones"`
 	Resolver struct {
-		Stats struct {
-			Queryv6         int `json:"Queryv6"`
-			Responsev6      int `json:"Responsev6"`
-			NXDOMAIN        int `json:"NXDOMAIN"`
-			Truncated       int `json:"Truncated"`
-			Retry           int `json:"Retry"`
-			ValAttempt      int `json:"ValAttempt"`
-			ValOk           int `json:"ValOk"`
-			ValNegOk        int `json:"ValNegOk"`
-			QryRTT100       int `json:"QryRTT100"`
-			QryRTT500       int `json:"QryRTT500"`
-			BucketSize      int `json:"BucketSize"`
-			ClientCookieOut int `json:"ClientCookieOut"`
-			ServerCookieOut int `json:"ServerCookieOut"`
-			CookieIn        int `json:"CookieIn"`
-			CookieClientOk  int `json:"CookieClientOk"`
-			Priming         int `json:"Priming"`
-		} `json:"stats"`
-		QTypes     QTypes `json:"qtypes"`
-		Cache      QTypes `json:"cache"`
-		CacheStats struct {
-			CacheHits    int `json:"CacheHits"`
-			CacheMisses  int `json:"CacheMisses"`
-			QueryHits    int `json:"QueryHits"`
-			QueryMisses  int `json:"QueryMisses"`
-			DeleteLRU    int `json:"DeleteLRU"`
-			DeleteTTL    int `json:"DeleteTTL"`
-			CacheNodes   int `json:"CacheNodes"`
-			CacheBuckets int `json:"CacheBuckets"`
-			TreeMemTotal int `json:"TreeMemTotal"`
-			TreeMemInUse int `json:"TreeMemInUse"`
-			TreeMemMax   int `json:"TreeMemMax"`
-			HeapMemTotal int `json:"HeapMemTotal"`
-			HeapMemInUse int `json:"HeapMemInUse"`
-			HeapMemMax   int `json:"HeapMemMax"`
-		} `json:"cache-stats"`
-		Adb struct {
-			Nentries   int `json:"nentries"`
-			Entriescnt int `json:"entriescnt"`
-			Nnames     int `json:"nnames"`
-			Namescnt   int `json:"namescnt"`
-		} `json:"adb"`
+		Stats      JsonCounters `json:"stats"`
+		QTypes     JsonCounters `json:"qtypes"`
+		Cache      JsonCounters `json:"cache"`
+		CacheStats JsonCounters `json:"cachestats"`
+		Adb        JsonCounters `json:"adb"`
 	} `json:"resolver"`
 }
 
@@ -603,242 +109,33 @@ func (bv *BindView) toMetrics(metric_time time.Time) []*Metric {
 	metrics := make([]*Metric, 0)
 	zone_metrics := make([]*Metric, 0)
 	for _, zone := range bv.Zones {
+		if zone == nil {
+			continue
+		}
 		zone_metrics = append(zone_metrics, zone.toMetrics(metric_time)...)
 	}
 	metrics = append(metrics, zone_metrics...)
-	stats_tag := &MetricTag{"type", "resstats"}
-	resolver_stats_metrics := make([]*Metric, 0)
-	resolver_stats_metrics = append(resolver_stats_metrics, &Metric{
-		Name:      "Queryv6",
-		Value:     int64(bv.Resolver.Stats.Queryv6),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{stats_tag},
-	})
-	resolver_stats_metrics = append(resolver_stats_metrics, &Metric{
-		Name:      "Responsev6",
-		Value:     int64(bv.Resolver.Stats.Responsev6),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{stats_tag},
-	})
-	resolver_stats_metrics = append(resolver_stats_metrics, &Metric{
-		Name:      "NXDOMAIN",
-		Value:     int64(bv.Resolver.Stats.NXDOMAIN),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{stats_tag},
-	})
-	resolver_stats_metrics = append(resolver_stats_metrics, &Metric{
-		Name:      "Truncated",
-		Value:     int64(bv.Resolver.Stats.Truncated),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{stats_tag},
-	})
-	resolver_stats_metrics = append(resolver_stats_metrics, &Metric{
-		Name:      "Retry",
-		Value:     int64(bv.Resolver.Stats.Retry),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{stats_tag},
-	})
-	resolver_stats_metrics = append(resolver_stats_metrics, &Metric{
-		Name:      "ValAttempt",
-		Value:     int64(bv.Resolver.Stats.ValAttempt),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{stats_tag},
-	})
-	resolver_stats_metrics = append(resolver_stats_metrics, &Metric{
-		Name:      "ValOk",
-		Value:     int64(bv.Resolver.Stats.ValOk),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{stats_tag},
-	})
-	resolver_stats_metrics = append(resolver_stats_metrics, &Metric{
-		Name:      "ValNegOk",
-		Value:     int64(bv.Resolver.Stats.ValNegOk),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{stats_tag},
-	})
-	resolver_stats_metrics = append(resolver_stats_metrics, &Metric{
-		Name:      "QryRTT100",
-		Value:     int64(bv.Resolver.Stats.QryRTT100),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{stats_tag},
-	})
-	resolver_stats_metrics = append(resolver_stats_metrics, &Metric{
-		Name:      "QryRTT500",
-		Value:     int64(bv.Resolver.Stats.QryRTT500),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{stats_tag},
-	})
-	resolver_stats_metrics = append(resolver_stats_metrics, &Metric{
-		Name:      "BucketSize",
-		Value:     int64(bv.Resolver.Stats.BucketSize),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{stats_tag},
-	})
-	resolver_stats_metrics = append(resolver_stats_metrics, &Metric{
-		Name:      "ClientCookieOut",
-		Value:     int64(bv.Resolver.Stats.ClientCookieOut),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{stats_tag},
-	})
-	resolver_stats_metrics = append(resolver_stats_metrics, &Metric{
-		Name:      "ServerCookieOut",
-		Value:     int64(bv.Resolver.Stats.ServerCookieOut),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{stats_tag},
-	})
-	resolver_stats_metrics = append(resolver_stats_metrics, &Metric{
-		Name:      "CookieIn",
-		Value:     int64(bv.Resolver.Stats.CookieIn),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{stats_tag},
-	})
-	resolver_stats_metrics = append(resolver_stats_metrics, &Metric{
-		Name:      "CookieClientOk",
-		Value:     int64(bv.Resolver.Stats.CookieClientOk),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{stats_tag},
-	})
-	resolver_stats_metrics = append(resolver_stats_metrics, &Metric{
-		Name:      "Priming",
-		Value:     int64(bv.Resolver.Stats.Priming),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{stats_tag},
-	})
-	metrics = append(metrics, resolver_stats_metrics...)
-
-	qtypes_tag := &MetricTag{"type", "resqtype"}
-	resolver_qtypes_metrics := bv.Resolver.QTypes.toMetrics(metric_time)
-	for _, metric := range resolver_qtypes_metrics {
-		metric_tags := make([]*MetricTag, 0, len(metric.Tags)+1)
-		metric_tags = append(metric_tags, qtypes_tag)
-		metric_tags = append(metric_tags, metric.Tags...)
-		metric.Tags = metric_tags
-	}
-	metrics = append(metrics, resolver_qtypes_metrics...)
-	cache_tag := &MetricTag{"type", "cache"}
-	resolver_cache_metrics := bv.Resolver.Cache.toMetrics(metric_time)
-	for _, metric := range resolver_cache_metrics {
-		metric_tags := make([]*MetricTag, 0, len(metric.Tags)+1)
-		metric_tags = append(metric_tags, cache_tag)
-		metric_tags = append(metric_tags, metric.Tags...)
-		metric.Tags = metric_tags
-	}
-	metrics = append(metrics, resolver_cache_metrics...)
 
-	cachestats_tag := &MetricTag{"type", "cachestats"}
-	resolver_cache_stats_metrics := make([]*Metric, 0)
-	resolver_cache_stats_metrics = append(resolver_cache_stats_metrics, &Metric{
-		Name:      "CacheHits",
-		Value:     int64(bv.Resolver.CacheStats.CacheHits),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{cachestats_tag},
-	})
-	resolver_cache_stats_metrics = append(resolver_cache_stats_metrics, &Metric{
-		Name:      "CacheMisses",
-		Value:     int64(bv.Resolver.CacheStats.CacheMisses),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{cachestats_tag},
-	})
-	resolver_cache_stats_metrics = append(resolver_cache_stats_metrics, &Metric{
-		Name:      "QueryHits",
-		Value:     int64(bv.Resolver.CacheStats.QueryHits),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{cachestats_tag},
-	})
-	resolver_cache_stats_metrics = append(resolver_cache_stats_metrics, &Metric{
-		Name:      "QueryMisses",
-		Value:     int64(bv.Resolver.CacheStats.QueryMisses),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{cachestats_tag},
-	})
-	resolver_cache_stats_metrics = append(resolver_cache_stats_metrics, &Metric{
-		Name:      "DeleteLRU",
-		Value:     int64(bv.Resolver.CacheStats.DeleteLRU),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{cachestats_tag},
-	})
-	resolver_cache_stats_metrics = append(resolver_cache_stats_metrics, &Metric{
-		Name:      "DeleteTTL",
-		Value:     int64(bv.Resolver.CacheStats.DeleteTTL),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{cachestats_tag},
-	})
-	resolver_cache_stats_metrics = append(resolver_cache_stats_metrics, &Metric{
-		Name:      "CacheNodes",
-		Value:     int64(bv.Resolver.CacheStats.CacheNodes),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{cachestats_tag},
-	})
-	resolver_cache_stats_metrics = append(resolver_cache_stats_metrics, &Metric{
-		Name:      "CacheBuckets",
-		Value:     int64(bv.Resolver.CacheStats.CacheBuckets),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{cachestats_tag},
-	})
-	resolver_cache_stats_metrics = append(resolver_cache_stats_metrics, &Metric{
-		Name:      "TreeMemTotal",
-		Value:     int64(bv.Resolver.CacheStats.TreeMemTotal),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{cachestats_tag},
-	})
-	resolver_cache_stats_metrics = append(resolver_cache_stats_metrics, &Metric{
-		Name:      "TreeMemInUse",
-		Value:     int64(bv.Resolver.CacheStats.TreeMemInUse),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{cachestats_tag},
-	})
-	resolver_cache_stats_metrics = append(resolver_cache_stats_metrics, &Metric{
-		Name:      "TreeMemMax",
-		Value:     int64(bv.Resolver.CacheStats.TreeMemMax),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{cachestats_tag},
-	})
-	resolver_cache_stats_metrics = append(resolver_cache_stats_metrics, &Metric{
-		Name:      "HeapMemTotal",
-		Value:     int64(bv.Resolver.CacheStats.HeapMemTotal),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{cachestats_tag},
-	})
-	resolver_cache_stats_metrics = append(resolver_cache_stats_metrics, &Metric{
-		Name:      "HeapMemInUse",
-		Value:     int64(bv.Resolver.CacheStats.HeapMemInUse),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{cachestats_tag},
-	})
-	resolver_cache_stats_metrics = append(resolver_cache_stats_metrics, &Metric{
-		Name:      "HeapMemMax",
-		Value:     int64(bv.Resolver.CacheStats.HeapMemMax),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{cachestats_tag},
-	})
-	metrics = append(metrics, resolver_cache_stats_metrics...)
-	adb_tag := &MetricTag{"type", "adbstat"}
-	resolver_adb_metrics := make([]*Metric, 0)
-	resolver_adb_metrics = append(resolver_adb_metrics, &Metric{
-		Name:      "nentries",
-		Value:     int64(bv.Resolver.Adb.Nentries),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{adb_tag},
-	})
-	resolver_adb_metrics = append(resolver_adb_metrics, &Metric{
-		Name:      "entriescnt",
-		Value:     int64(bv.Resolver.Adb.Entriescnt),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{adb_tag},
-	})
-	resolver_adb_metrics = append(resolver_adb_metrics, &Metric{
-		Name:      "nnames",
-		Value:     int64(bv.Resolver.Adb.Nnames),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{adb_tag},
-	})
-	resolver_adb_metrics = append(resolver_adb_metrics, &Metric{
-		Name:      "namescnt",
-		Value:     int64(bv.Resolver.Adb.Namescnt),
-		Timestamp: metric_time,
-		Tags:      []*MetricTag{adb_tag},
-	})
-	metrics = append(metrics, resolver_adb_metrics...)
+	resolver_counters := []struct {
+		Tag      *MetricTag
+		Counters JsonCounters
+	}{
+		{&MetricTag{"type", "resstats"}, bv.Resolver.Stats},
+		{&MetricTag{"type", "resqtype"}, bv.Resolver.QTypes},
+		{&MetricTag{"type", "cache"}, bv.Resolver.Cache},
+		{&MetricTag{"type", "cachestats"}, bv.Resolver.CacheStats},
+		{&MetricTag{"type", "adbstat"}, bv.Resolver.Adb},
+	}
+	for _, resolver_counter := range resolver_counters {
+		resolver_metrics := resolver_counter.Counters.toMetrics(metric_time)
+		for _, metric := range resolver_metrics {
+			metric_tags := make([]*MetricTag, 0, len(metric.Tags)+1)
+			metric_tags = append(metric_tags, resolver_counter.Tag)
+			metric_tags = append(metric_tags, metric.Tags...)
+			metric.Tags = metric_tags
+		}
+		metrics = append(metrics, resolver_metrics...)
+	}
 
 	return metrics
 }
@@ -865,7 +162,7 @@ func (s *SocketMgrSocket) toMetric(metric_time time.Time) *Metric {
 			socket_metric.Tags,
 			&MetricTag{
 				"local-address",
-				strings.ReplaceAll(s.LocalAddress, ".", "_"),
+				s.LocalAddress,
 			},
 		)
 	}
@@ -874,7 +171,7 @@ func (s *SocketMgrSocket) toMetric(metric_time time.Time) *Metric {
 			socket_metric.Tags,
 			&MetricTag{
 				"peer-address",
-				strings.ReplaceAll(s.PeerAddress, ".", "_"),
+				s.PeerAddress,
 			},
 		)
 	}
@@ -987,55 +284,25 @@ func (c *Context) toMetric(metric_time time.Time) []*Metric {
 }
 
 type ZoneView struct {
-	Name          string    `json:"name"`
-	Class         string    `json:"class"`
-	Serial        int       `json:"serial"`
-	Type          string    `json:"type"`
-	Loaded        time.Time `json:"loaded"`
-	Expires       time.Time `json:"expires,omitempty"`
-	Refresh       time.Time `json:"refresh,omitempty"`
-	RCodes        RCode     `json:"rcodes,omitempty"`
-	QTypes        QTypes    `json:"qtypes"`
-	DnsSecSign    DnsSec    `json:"dnssec-sign,omitempty"`
-	DnsSecRefresh DnsSec    `json:"dnssec-refresh,omitempty"`
+	Name          string       `json:"name"`
+	Class         string       `json:"class"`
+	Serial        int          `json:"serial"`
+	Type          string       `json:"type"`
+	Loaded        time.Time    `json:"loaded"`
+	Expires       time.Time    `json:"expires,omitempty"`
+	Refresh       time.Time    `json:"refresh,omitempty"`
+	RCodes        JsonCounters `json:"rcodes,omitempty"`
+	QTypes        JsonCounters `json:"qtypes"`
+	GlueCache     JsonCounters `json:"gluecache,omitempty"`
+	DnsSecSign    DnsSec       `json:"dnssec-sign,omitempty"`
+	DnsSecRefresh DnsSec       `json:"dnssec-refresh,omitempty"`
 }
 
 func (z *ZoneView) toMetrics(metric_time time.Time) []*Metric {
 	metrics := make([]*Metric, 0)
-	zonename := ""
-	if strings.Contains(strings.ToLower(z.Name), "ip6.arpa") {
-		zone_grps := make([]string, 0, 10)
-		zone_name := z.Name
-		if strings.Contains(zone_name, "IP6.ARPA") {
-			zone_name = strings.Replace(zone_name, "IP6.ARPA", "", 1)
-			zone_grps = append(zone_grps, "IP6.ARPA")
-		} else {
-			zone_name = strings.Replace(zone_name, "ip6.arpa", "", 1)
-			zone_grps = append(zone_grps, "ip6.arpa")
-		}
-		zone_name = strings.Trim(zone_name, ".")
-		zone_name = strings.ReplaceAll(zone_name, ".", "")
-		for {
-			if len(zone_name) < 4 {
-				if len(zone_name) > 0 {
-					zone_grps = append(zone_grps, zone_name)
-				}
-				break
-			}
-			zone_grp := zone_name[len(zone_name)-4:]
-			zone_grps = append(zone_grps, zone_grp)
-			zone_name = zone_name[:len(zone_name)-4]
-		}
-		for idx := len(zone_grps) - 1; idx >= 0; idx-- {
-			zonename = zonename + zone_grps[idx] + "."
-		}
-		zonename = zonename[:len(zonename)-1]
-	} else {
-		zonename = z.Name
-	}
-	zone_name_tag := &MetricTag{"zone", strings.ReplaceAll(zonename, ".", "_")}
+	zone_name_tag := &MetricTag{"zone", z.Name}
 	zone_class_tag := &MetricTag{"class", z.Class}
-	zone_type_tag := &MetricTag{"type", z.Type}
+	zone_type_tag := &MetricTag{"zone_type", z.Type}
 
 	rcode_tag := &MetricTag{"type", "rcode"}
 	rcode_metrics := z.RCodes.toMetrics(metric_time)
@@ -1067,6 +334,21 @@ func (z *ZoneView) toMetrics(metric_time time.Time) []*Metric {
 		}
 	}
 
+	gluecache_tag := &MetricTag{"type", "gluecache"}
+	gluecache_metrics := z.GlueCache.toMetrics(metric_time)
+	for _, gluecache_metric := range gluecache_metrics {
+		if gluecache_metric.Value != 0 {
+			gluecache_metric_tags := make([]*MetricTag, 0, len(gluecache_metric.Tags)+4)
+			gluecache_metric_tags = append(gluecache_metric_tags, zone_name_tag)
+			gluecache_metric_tags = append(gluecache_metric_tags, zone_class_tag)
+			gluecache_metric_tags = append(gluecache_metric_tags, zone_type_tag)
+			gluecache_metric_tags = append(gluecache_metric_tags, gluecache_tag)
+			gluecache_metric_tags = append(gluecache_metric_tags, gluecache_metric.Tags...)
+			gluecache_metric.Tags = gluecache_metric_tags
+			metrics = append(metrics, gluecache_metric)
+		}
+	}
+
 	dnssecsign_tag := &MetricTag{"type", "dnssec-sign"}
 	dnssecsign_metrics := z.DnsSecSign.toMetrics(metric_time)
 	for _, dnssecsign_metric := range dnssecsign_metrics {
@@ -1208,10 +490,6 @@ func (t *Traffic) UnmarshalJSON(data []byte) error {
 		attribute_name = strings.ReplaceAll(attribute_name, `"`, "")
 		attribute_name = strings.ReplaceAll(attribute_name, ":", "")
 		attribute_pieces := traffic_attribute.FindStringSubmatch(attribute_name)
-		protocol := attribute_pieces[1]
-		traffic_type := attribute_pieces[2]
-		traffic_type = strings.ReplaceAll(traffic_type, "s-sizes", "-size")
-		ipver := attribute_pieces[3]
 
 		// Parse the attribute value from the string
 		attribute_value := traffic[:strings.Index(traffic, "}")+1]
@@ -1219,7 +497,8 @@ func (t *Traffic) UnmarshalJSON(data []byte) error {
 		// Strip the attribute value from the string
 		traffic = strings.Replace(traffic, attribute_value, "", 1)
 
-		// Strip the beginning and ending curly braces
+		// Strip the whitespace after the colon and the curly braces
+		attribute_value = strings.TrimSpace(attribute_value)
 		attribute_value = attribute_value[1 : len(attribute_value)-1]
 
 		// Strip beginning and ending whitespace
@@ -1228,7 +507,11 @@ func (t *Traffic) UnmarshalJSON(data []byte) error {
 		// Parse the attribute value into pieces
 		attribute_value_pieces := strings.Split(attribute_value, ",")
 
-		if len(attribute_value_pieces) > 0 {
+		// Skip the histograms of any traffic this plugin does not know about
+		if attribute_pieces != nil {
+			protocol := attribute_pieces[1]
+			traffic_type := strings.ReplaceAll(attribute_pieces[2], "s-sizes", "-size")
+			ipver := attribute_pieces[3]
 			for _, piece := range attribute_value_pieces {
 				// Split the key value pair
 				kv := strings.Split(piece, ":")
@@ -1268,471 +551,77 @@ func (t *Traffic) UnmarshalJSON(data []byte) error {
 }
 
 func ReadJsonStats(statsData []byte) error {
+	stats, err := parseJsonStats(statsData)
+	if err != nil {
+		return err
+	}
+	plugin.setStatistics(stats)
+	return nil
+}
+
+func parseJsonStats(statsData []byte) (*statistics, error) {
 	// Read the JSON statistics
 	var jsonStats bindJsonStats
 
 	err := json.Unmarshal(statsData, &jsonStats)
 	if err != nil {
 		fmt.Printf("Error parsing JSON: %s\n", err)
-		return err
+		return nil, err
 	}
 
 	return_metrics := make([]*Metric, 0)
 
-	opscodes_metrics := make([]*Metric, 0)
-	opcodes_tag := &MetricTag{"server", "opcodes"}
-	opscodes_metrics = append(opscodes_metrics, &Metric{
-		Name:      "Query",
-		Value:     int64(jsonStats.OpCodes.Query),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{opcodes_tag},
-	})
-	opscodes_metrics = append(opscodes_metrics, &Metric{
-		Name:      "IQuery",
-		Value:     int64(jsonStats.OpCodes.IQuery),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{opcodes_tag},
-	})
-	opscodes_metrics = append(opscodes_metrics, &Metric{
-		Name:      "Status",
-		Value:     int64(jsonStats.OpCodes.Status),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{opcodes_tag},
-	})
-	opscodes_metrics = append(opscodes_metrics, &Metric{
-		Name:      "Notify",
-		Value:     int64(jsonStats.OpCodes.Notify),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{opcodes_tag},
-	})
-	opscodes_metrics = append(opscodes_metrics, &Metric{
-		Name:      "Update",
-		Value:     int64(jsonStats.OpCodes.Update),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{opcodes_tag},
-	})
-	opscodes_metrics = append(opscodes_metrics, &Metric{
-		Name:      "Reserved6",
-		Value:     int64(jsonStats.OpCodes.Reserved6),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{opcodes_tag},
-	})
-	opscodes_metrics = append(opscodes_metrics, &Metric{
-		Name:      "Reserved7",
-		Value:     int64(jsonStats.OpCodes.Reserved7),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{opcodes_tag},
-	})
-	opscodes_metrics = append(opscodes_metrics, &Metric{
-		Name:      "Reserved8",
-		Value:     int64(jsonStats.OpCodes.Reserved8),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{opcodes_tag},
-	})
-	opscodes_metrics = append(opscodes_metrics, &Metric{
-		Name:      "Reserved9",
-		Value:     int64(jsonStats.OpCodes.Reserved9),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{opcodes_tag},
-	})
-	opscodes_metrics = append(opscodes_metrics, &Metric{
-		Name:      "Reserved10",
-		Value:     int64(jsonStats.OpCodes.Reserved10),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{opcodes_tag},
-	})
-	opscodes_metrics = append(opscodes_metrics, &Metric{
-		Name:      "Reserved11",
-		Value:     int64(jsonStats.OpCodes.Reserved11),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{opcodes_tag},
-	})
-	opscodes_metrics = append(opscodes_metrics, &Metric{
-		Name:      "Reserved12",
-		Value:     int64(jsonStats.OpCodes.Reserved12),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{opcodes_tag},
-	})
-	opscodes_metrics = append(opscodes_metrics, &Metric{
-		Name:      "Reserved13",
-		Value:     int64(jsonStats.OpCodes.Reserved13),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{opcodes_tag},
-	})
-	opscodes_metrics = append(opscodes_metrics, &Metric{
-		Name:      "Reserved14",
-		Value:     int64(jsonStats.OpCodes.Reserved14),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{opcodes_tag},
-	})
-	opscodes_metrics = append(opscodes_metrics, &Metric{
-		Name:      "Reserved15",
-		Value:     int64(jsonStats.OpCodes.Reserved15),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{opcodes_tag},
-	})
-	for _, opcode_metric := range opscodes_metrics {
-		if opcode_metric.Value != 0 {
-			return_metrics = append(return_metrics, opcode_metric)
-		}
-	}
-
-	rcodes_tag := &MetricTag{"server", "rcodes"}
-	json_rcodes := jsonStats.RCodes.toMetrics(jsonStats.CurrentTime)
-	for _, rcode_metric := range json_rcodes {
-		if rcode_metric.Value != 0 {
-			metric_tags := make([]*MetricTag, 0, len(rcode_metric.Tags)+1)
-			metric_tags = append(metric_tags, rcodes_tag)
-			metric_tags = append(metric_tags, rcode_metric.Tags...)
-			rcode_metric.Tags = metric_tags
-			return_metrics = append(return_metrics, rcode_metric)
-		}
-	}
-
-	qtypes_tag := &MetricTag{"server", "qtypes"}
-	json_qtypes := jsonStats.QTypes.toMetrics(jsonStats.CurrentTime)
-	for _, qtype_metric := range json_qtypes {
-		if qtype_metric.Value != 0 {
-			metric_tags := make([]*MetricTag, 0, len(qtype_metric.Tags)+1)
-			metric_tags = append(metric_tags, qtypes_tag)
-			metric_tags = append(metric_tags, qtype_metric.Tags...)
-			qtype_metric.Tags = metric_tags
-			return_metrics = append(return_metrics, qtype_metric)
-		}
-	}
-
-	nsstat_tag := &MetricTag{"server", "nsstat"}
-	nsstat_metrics := make([]*Metric, 0)
-	nsstat_metrics = append(nsstat_metrics, &Metric{
-		Name:      "AuthQryRej",
-		Value:     int64(jsonStats.NSStats.AuthQryRej),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{nsstat_tag},
-	})
-	nsstat_metrics = append(nsstat_metrics, &Metric{
-		Name:      "CookieIn",
-		Value:     int64(jsonStats.NSStats.CookieIn),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{nsstat_tag},
-	})
-	nsstat_metrics = append(nsstat_metrics, &Metric{
-		Name:      "CookieMatch",
-		Value:     int64(jsonStats.NSStats.CookieMatch),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{nsstat_tag},
-	})
-	nsstat_metrics = append(nsstat_metrics, &Metric{
-		Name:      "CookieNew",
-		Value:     int64(jsonStats.NSStats.CookieNew),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{nsstat_tag},
-	})
-	nsstat_metrics = append(nsstat_metrics, &Metric{
-		Name:      "ECSOpt",
-		Value:     int64(jsonStats.NSStats.ECSOpt),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{nsstat_tag},
-	})
-	nsstat_metrics = append(nsstat_metrics, &Metric{
-		Name:      "QryAuthAns",
-		Value:     int64(jsonStats.NSStats.QryAuthAns),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{nsstat_tag},
-	})
-	nsstat_metrics = append(nsstat_metrics, &Metric{
-		Name:      "QryFailure",
-		Value:     int64(jsonStats.NSStats.QryFailure),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{nsstat_tag},
-	})
-	nsstat_metrics = append(nsstat_metrics, &Metric{
-		Name:      "QryNXDOMAIN",
-		Value:     int64(jsonStats.NSStats.QryNXDOMAIN),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{nsstat_tag},
-	})
-	nsstat_metrics = append(nsstat_metrics, &Metric{
-		Name:      "QryNoauthAns",
-		Value:     int64(jsonStats.NSStats.QryNoauthAns),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{nsstat_tag},
-	})
-	nsstat_metrics = append(nsstat_metrics, &Metric{
-		Name:      "QryNxrrset",
-		Value:     int64(jsonStats.NSStats.QryNxrrset),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{nsstat_tag},
-	})
-	nsstat_metrics = append(nsstat_metrics, &Metric{
-		Name:      "QryReferral",
-		Value:     int64(jsonStats.NSStats.QryReferral),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{nsstat_tag},
-	})
-	nsstat_metrics = append(nsstat_metrics, &Metric{
-		Name:      "QrySuccess",
-		Value:     int64(jsonStats.NSStats.QrySuccess),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{nsstat_tag},
-	})
-	nsstat_metrics = append(nsstat_metrics, &Metric{
-		Name:      "QryTCP",
-		Value:     int64(jsonStats.NSStats.QryTCP),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{nsstat_tag},
-	})
-	nsstat_metrics = append(nsstat_metrics, &Metric{
-		Name:      "QryUDP",
-		Value:     int64(jsonStats.NSStats.QryUDP),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{nsstat_tag},
-	})
-	nsstat_metrics = append(nsstat_metrics, &Metric{
-		Name:      "RecQryRej",
-		Value:     int64(jsonStats.NSStats.RecQryRej),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{nsstat_tag},
-	})
-	nsstat_metrics = append(nsstat_metrics, &Metric{
-		Name:      "ReqEdns0",
-		Value:     int64(jsonStats.NSStats.ReqEdns0),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{nsstat_tag},
-	})
-	nsstat_metrics = append(nsstat_metrics, &Metric{
-		Name:      "ReqTCP",
-		Value:     int64(jsonStats.NSStats.ReqTCP),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{nsstat_tag},
-	})
-	nsstat_metrics = append(nsstat_metrics, &Metric{
-		Name:      "Requestv4",
-		Value:     int64(jsonStats.NSStats.Requestv4),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{nsstat_tag},
-	})
-	nsstat_metrics = append(nsstat_metrics, &Metric{
-		Name:      "Requestv6",
-		Value:     int64(jsonStats.NSStats.Requestv6),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{nsstat_tag},
-	})
-	nsstat_metrics = append(nsstat_metrics, &Metric{
-		Name:      "RespEDNS0",
-		Value:     int64(jsonStats.NSStats.RespEDNS0),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{nsstat_tag},
-	})
-	nsstat_metrics = append(nsstat_metrics, &Metric{
-		Name:      "Response",
-		Value:     int64(jsonStats.NSStats.Response),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{nsstat_tag},
-	})
-	nsstat_metrics = append(nsstat_metrics, &Metric{
-		Name:      "TCPConnHighWater",
-		Value:     int64(jsonStats.NSStats.TCPConnHighWater),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{nsstat_tag},
-	})
-	nsstat_metrics = append(nsstat_metrics, &Metric{
-		Name:      "TruncatedResp",
-		Value:     int64(jsonStats.NSStats.TruncatedResp),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{nsstat_tag},
-	})
-	for _, nsstat_metric := range nsstat_metrics {
-		if nsstat_metric.Value != 0 {
-			return_metrics = append(return_metrics, nsstat_metric)
-		}
-	}
-
-	zone_tag := &MetricTag{"server", "zonestats"}
-	zonestats_metrics := make([]*Metric, 0)
-	zonestats_metrics = append(zonestats_metrics, &Metric{
-		Name:      "AXFRReqv4",
-		Value:     int64(jsonStats.ZoneStats.AXFRReqv4),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{zone_tag},
-	})
-	zonestats_metrics = append(zonestats_metrics, &Metric{
-		Name:      "IXFRReqv4",
-		Value:     int64(jsonStats.ZoneStats.IXFRReqv4),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{zone_tag},
-	})
-	zonestats_metrics = append(zonestats_metrics, &Metric{
-		Name:      "NotifyInv4",
-		Value:     int64(jsonStats.ZoneStats.NotifyInv4),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{zone_tag},
-	})
-	zonestats_metrics = append(zonestats_metrics, &Metric{
-		Name:      "SOAOutv4",
-		Value:     int64(jsonStats.ZoneStats.SOAOutv4),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{zone_tag},
-	})
-	zonestats_metrics = append(zonestats_metrics, &Metric{
-		Name:      "XfrSuccess",
-		Value:     int64(jsonStats.ZoneStats.XfrSuccess),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{zone_tag},
-	})
-	for _, zonestats_metric := range zonestats_metrics {
-		if zonestats_metric.Value != 0 {
-			return_metrics = append(return_metrics, zonestats_metric)
-		}
-	}
-
-	bind_view_bind_tag := &MetricTag{"view", "_bind"}
-	bind_view_bind_metrics := jsonStats.Views.Bind.toMetrics(jsonStats.CurrentTime)
-	for _, bind_view_bind_metric := range bind_view_bind_metrics {
-		if bind_view_bind_metric.Value != 0 {
-			view_metrics := make([]*MetricTag, 0, len(bind_view_bind_metric.Tags)+1)
-			view_metrics = append(view_metrics, bind_view_bind_tag)
-			view_metrics = append(view_metrics, bind_view_bind_metric.Tags...)
-			bind_view_bind_metric.Tags = view_metrics
-			return_metrics = append(return_metrics, bind_view_bind_metric)
+	// Process the server counters
+	server_counters := []struct {
+		Tag      *MetricTag
+		Counters JsonCounters
+	}{
+		{&MetricTag{"server", "opcodes"}, jsonStats.OpCodes},
+		{&MetricTag{"server", "rcodes"}, jsonStats.RCodes},
+		{&MetricTag{"server", "qtypes"}, jsonStats.QTypes},
+		{&MetricTag{"server", "nsstat"}, jsonStats.NSStats},
+		{&MetricTag{"server", "zonestats"}, jsonStats.ZoneStats},
+	}
+	for _, server_counter := range server_counters {
+		for _, server_metric := range server_counter.Counters.toMetrics(jsonStats.CurrentTime) {
+			if server_metric.Value != 0 {
+				metric_tags := make([]*MetricTag, 0, len(server_metric.Tags)+1)
+				metric_tags = append(metric_tags, server_counter.Tag)
+				metric_tags = append(metric_tags, server_metric.Tags...)
+				server_metric.Tags = metric_tags
+				return_metrics = append(return_metrics, server_metric)
+			}
 		}
 	}
 
-	bind_view_default_tag := &MetricTag{"view", "_default"}
-	bind_view_default_metrics := jsonStats.Views.Default.toMetrics(jsonStats.CurrentTime)
-	for _, bind_view_default_metric := range bind_view_default_metrics {
-		if bind_view_default_metric.Value != 0 {
-			view_metrics := make([]*MetricTag, 0, len(bind_view_default_metric.Tags)+1)
-			view_metrics = append(view_metrics, bind_view_default_tag)
-			view_metrics = append(view_metrics, bind_view_default_metric.Tags...)
-			bind_view_default_metric.Tags = view_metrics
-			return_metrics = append(return_metrics, bind_view_default_metric)
+	// Process the views sorted by name so the output order is stable
+	view_names := make([]string, 0, len(jsonStats.Views))
+	for view_name := range jsonStats.Views {
+		view_names = append(view_names, view_name)
+	}
+	sort.Strings(view_names)
+	for _, view_name := range view_names {
+		bind_view := jsonStats.Views[view_name]
+		bind_view_tag := &MetricTag{"view", view_name}
+		bind_view_metrics := bind_view.toMetrics(jsonStats.CurrentTime)
+		for _, bind_view_metric := range bind_view_metrics {
+			if bind_view_metric.Value != 0 {
+				view_metrics := make([]*MetricTag, 0, len(bind_view_metric.Tags)+1)
+				view_metrics = append(view_metrics, bind_view_tag)
+				view_metrics = append(view_metrics, bind_view_metric.Tags...)
+				bind_view_metric.Tags = view_metrics
+				return_metrics = append(return_metrics, bind_view_metric)
+			}
 		}
 	}
 
 	sockstats_tag := &MetricTag{"server", "sockstats"}
-	sockstats_metrics := make([]*Metric, 0)
-	sockstats_metrics = append(sockstats_metrics, &Metric{
-		Name:      "RawActive",
-		Value:     int64(jsonStats.SocketStats.RawActive),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{sockstats_tag},
-	})
-	sockstats_metrics = append(sockstats_metrics, &Metric{
-		Name:      "RawOpen",
-		Value:     int64(jsonStats.SocketStats.RawOpen),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{sockstats_tag},
-	})
-	sockstats_metrics = append(sockstats_metrics, &Metric{
-		Name:      "TCP4Accept",
-		Value:     int64(jsonStats.SocketStats.TCP4Accept),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{sockstats_tag},
-	})
-	sockstats_metrics = append(sockstats_metrics, &Metric{
-		Name:      "TCP4Active",
-		Value:     int64(jsonStats.SocketStats.TCP4Active),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{sockstats_tag},
-	})
-	sockstats_metrics = append(sockstats_metrics, &Metric{
-		Name:      "TCP4Close",
-		Value:     int64(jsonStats.SocketStats.TCP4Close),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{sockstats_tag},
-	})
-	sockstats_metrics = append(sockstats_metrics, &Metric{
-		Name:      "TCP4Conn",
-		Value:     int64(jsonStats.SocketStats.TCP4Conn),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{sockstats_tag},
-	})
-	sockstats_metrics = append(sockstats_metrics, &Metric{
-		Name:      "TCP4Open",
-		Value:     int64(jsonStats.SocketStats.TCP4Open),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{sockstats_tag},
-	})
-	sockstats_metrics = append(sockstats_metrics, &Metric{
-		Name:      "TCP4RecvErr",
-		Value:     int64(jsonStats.SocketStats.TCP4RecvErr),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{sockstats_tag},
-	})
-	sockstats_metrics = append(sockstats_metrics, &Metric{
-		Name:      "TCP6Accept",
-		Value:     int64(jsonStats.SocketStats.TCP6Accept),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{sockstats_tag},
-	})
-	sockstats_metrics = append(sockstats_metrics, &Metric{
-		Name:      "TCP6Active",
-		Value:     int64(jsonStats.SocketStats.TCP6Active),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{sockstats_tag},
-	})
-	sockstats_metrics = append(sockstats_metrics, &Metric{
-		Name:      "TCP6Close",
-		Value:     int64(jsonStats.SocketStats.TCP6Close),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{sockstats_tag},
-	})
-	sockstats_metrics = append(sockstats_metrics, &Metric{
-		Name:      "TCP6Conn",
-		Value:     int64(jsonStats.SocketStats.TCP6Conn),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{sockstats_tag},
-	})
-	sockstats_metrics = append(sockstats_metrics, &Metric{
-		Name:      "TCP6Open",
-		Value:     int64(jsonStats.SocketStats.TCP6Open),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{sockstats_tag},
-	})
-	sockstats_metrics = append(sockstats_metrics, &Metric{
-		Name:      "UDP4Active",
-		Value:     int64(jsonStats.SocketStats.UDP4Active),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{sockstats_tag},
-	})
-	sockstats_metrics = append(sockstats_metrics, &Metric{
-		Name:      "UDP4Close",
-		Value:     int64(jsonStats.SocketStats.UDP4Close),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{sockstats_tag},
-	})
-	sockstats_metrics = append(sockstats_metrics, &Metric{
-		Name:      "UDP4Open",
-		Value:     int64(jsonStats.SocketStats.UDP4Open),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{sockstats_tag},
-	})
-	sockstats_metrics = append(sockstats_metrics, &Metric{
-		Name:      "UDP6Active",
-		Value:     int64(jsonStats.SocketStats.UDP6Active),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{sockstats_tag},
-	})
-	sockstats_metrics = append(sockstats_metrics, &Metric{
-		Name:      "UDP6Close",
-		Value:     int64(jsonStats.SocketStats.UDP6Close),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{sockstats_tag},
-	})
-	sockstats_metrics = append(sockstats_metrics, &Metric{
-		Name:      "UDP6Conn",
-		Value:     int64(jsonStats.SocketStats.UDP6Conn),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{sockstats_tag},
-	})
-	sockstats_metrics = append(sockstats_metrics, &Metric{
-		Name:      "UDP6Open",
-		Value:     int64(jsonStats.SocketStats.UDP6Open),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{sockstats_tag},
-	})
-	for _, sockstats_metric := range sockstats_metrics {
+	for _, sockstats_metric := range jsonStats.SocketStats.toMetrics(jsonStats.CurrentTime) {
 		if sockstats_metric.Value != 0 {
+			metric_tags := make([]*MetricTag, 0, len(sockstats_metric.Tags)+1)
+			metric_tags = append(metric_tags, sockstats_tag)
+			metric_tags = append(metric_tags, sockstats_metric.Tags...)
+			sockstats_metric.Tags = metric_tags
 			return_metrics = append(return_metrics, sockstats_metric)
 		}
 	}
@@ -1769,54 +658,57 @@ func ReadJsonStats(statsData []byte) error {
 	}
 	return_metrics = append(return_metrics, task_mgr_metrics...)
 
-	memory_tag := &MetricTag{"server", "memory"}
-	return_metrics = append(return_metrics, &Metric{
-		Name:      "BlockSize",
-		Value:     int64(jsonStats.Memory.BlockSize),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{memory_tag},
-	})
-	return_metrics = append(return_metrics, &Metric{
-		Name:      "ContextSize",
-		Value:     int64(jsonStats.Memory.ContextSize),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{memory_tag},
-	})
-	return_metrics = append(return_metrics, &Metric{
-		Name:      "InUse",
-		Value:     int64(jsonStats.Memory.InUse),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{memory_tag},
-	})
-	return_metrics = append(return_metrics, &Metric{
-		Name:      "Lost",
-		Value:     int64(jsonStats.Memory.Lost),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{memory_tag},
-	})
-	return_metrics = append(return_metrics, &Metric{
-		Name:      "Malloced",
-		Value:     int64(jsonStats.Memory.Malloced),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{memory_tag},
-	})
-	return_metrics = append(return_metrics, &Metric{
-		Name:      "TotalUse",
-		Value:     int64(jsonStats.Memory.TotalUse),
-		Timestamp: jsonStats.CurrentTime,
-		Tags:      []*MetricTag{memory_tag},
-	})
+	// The memory statistics are missing when only some sections were read
+	if jsonStats.Memory != nil {
+		memory_tag := &MetricTag{"server", "memory"}
+		return_metrics = append(return_metrics, &Metric{
+			Name:      "BlockSize",
+			Value:     int64(jsonStats.Memory.BlockSize),
+			Timestamp: jsonStats.CurrentTime,
+			Tags:      []*MetricTag{memory_tag},
+		})
+		return_metrics = append(return_metrics, &Metric{
+			Name:      "ContextSize",
+			Value:     int64(jsonStats.Memory.ContextSize),
+			Timestamp: jsonStats.CurrentTime,
+			Tags:      []*MetricTag{memory_tag},
+		})
+		return_metrics = append(return_metrics, &Metric{
+			Name:      "InUse",
+			Value:     int64(jsonStats.Memory.InUse),
+			Timestamp: jsonStats.CurrentTime,
+			Tags:      []*MetricTag{memory_tag},
+		})
+		return_metrics = append(return_metrics, &Metric{
+			Name:      "Lost",
+			Value:     int64(jsonStats.Memory.Lost),
+			Timestamp: jsonStats.CurrentTime,
+			Tags:      []*MetricTag{memory_tag},
+		})
+		return_metrics = append(return_metrics, &Metric{
+			Name:      "Malloced",
+			Value:     int64(jsonStats.Memory.Malloced),
+			Timestamp: jsonStats.CurrentTime,
+			Tags:      []*MetricTag{memory_tag},
+		})
+		return_metrics = append(return_metrics, &Metric{
+			Name:      "TotalUse",
+			Value:     int64(jsonStats.Memory.TotalUse),
+			Timestamp: jsonStats.CurrentTime,
+			Tags:      []*MetricTag{memory_tag},
+		})
 
-	context_tag := &MetricTag{"server", "context"}
-	for _, context := range jsonStats.Memory.Contexts {
-		context_metrics := context.toMetric(jsonStats.CurrentTime)
-		for _, context_metric := range context_metrics {
-			context_metric_tags := make([]*MetricTag, 0, len(context_metric.Tags)+1)
-			context_metric_tags = append(context_metric_tags, context_tag)
-			context_metric_tags = append(context_metric_tags, context_metric.Tags...)
-			context_metric.Tags = context_metric_tags
-			if context_metric.Value != 0 {
-				return_metrics = append(return_metrics, context_metric)
+		context_tag := &MetricTag{"server", "context"}
+		for _, context := range jsonStats.Memory.Contexts {
+			context_metrics := context.toMetric(jsonStats.CurrentTime)
+			for _, context_metric := range context_metrics {
+				context_metric_tags := make([]*MetricTag, 0, len(context_metric.Tags)+1)
+				context_metric_tags = append(context_metric_tags, context_tag)
+				context_metric_tags = append(context_metric_tags, context_metric.Tags...)
+				context_metric.Tags = context_metric_tags
+				if context_metric.Value != 0 {
+					return_metrics = append(return_metrics, context_metric)
+				}
 			}
 		}
 	}
@@ -1832,6 +724,10 @@ func ReadJsonStats(statsData []byte) error {
 		}
 	}
 
-	plugin.returnMetrics = return_metrics
-	return nil
+	return &statistics{
+		Metrics:       return_metrics,
+		BootTime:      jsonStats.BootTime,
+		ConfigTime:    jsonStats.ConfigTime,
+		ServerVersion: jsonStats.Version,
+	}, nil
 }
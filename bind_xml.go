@@ -4,7 +4,6 @@ import (
 	"encoding/xml"
 	"fmt"
 	"strconv"
-	"strings"
 	"time"
 )
 
@@ -26,7 +25,7 @@ type bindXmlStats struct {
 				Total       int    `xml:"total"`
 			} `xml:"context"`
 		} `xml:"contexts"`
-		Summary struct {
+		Summary *struct {
 			BlockSize   int `xml:"BlockSize"`
 			ContextSize int `xml:"ContextSize"`
 			InUse       int `xml:"InUse"`
@@ -137,6 +136,26 @@ func (xc *XmlCounters) toMetrics(metric_time time.Time) []*Metric {
 	return metrics
 }
 
+// xmlServerCounterType maps the XML server counter types onto the server tag
+// values used by the JSON reader, so both formats produce the same metrics.
+func xmlServerCounterType(counter_type string) string {
+	switch counter_type {
+	case "opcode":
+		return "opcodes"
+	case "rcode":
+		return "rcodes"
+	case "qtype":
+		return "qtypes"
+	case "zonestat":
+		return "zonestats"
+	case "resstat":
+		return "resstats"
+	case "sockstat":
+		return "sockstats"
+	}
+	return counter_type
+}
+
 type XmlIp struct {
 	Tcp struct {
 		Counters []*XmlCounters `xml:"counters"`
@@ -181,17 +200,40 @@ func (xi *XmlIp) toMetrics(metric_time time.Time) []*Metric {
 }
 
 func ReadXmlStats(statsData []byte) error {
+	stats, err := parseXmlStats(statsData)
+	if err != nil {
+		return err
+	}
+	plugin.setStatistics(stats)
+	return nil
+}
+
+func parseXmlStats(statsData []byte) (*statistics, error) {
 	var xmlStats bindXmlStats
 
 	// Parse the XML statistics
 	err := xml.Unmarshal(statsData, &xmlStats)
 	if err != nil {
 		fmt.Printf("Error parsing XML: %s\n", err)
-		return err
+		return nil, err
 	}
 
 	returnMetrics := make([]*Metric, 0, 100)
 
+	// Process the server counter statistics
+	serverMetrics := make([]*Metric, 0, 100)
+	for _, server_counter := range xmlStats.Server.Counters {
+		server_tag := &MetricTag{"server", xmlServerCounterType(server_counter.Type)}
+		for _, metric := range server_counter.toMetrics(xmlStats.Server.CurrentTime) {
+			if metric.Value != 0 {
+				// Replace the counter type tag with the server tag used by the JSON reader
+				metric.Tags = []*MetricTag{server_tag}
+				serverMetrics = append(serverMetrics, metric)
+			}
+		}
+	}
+	returnMetrics = append(returnMetrics, serverMetrics...)
+
 	// Process the memory context statistics
 	context_tag := &MetricTag{"server", "context"}
 	contextMetrics := make([]*Metric, 0, 10)
@@ -270,46 +312,48 @@ func ReadXmlStats(statsData []byte) error {
 		}
 	}
 
-	// Process the memory statistics
-	memory_tag := &MetricTag{"server", "memory"}
-	memoryMetrics := make([]*Metric, 0, 10)
-	memoryMetrics = append(memoryMetrics, &Metric{
-		Name:      "BlockSize",
-		Value:     int64(xmlStats.Memory.Summary.BlockSize),
-		Timestamp: xmlStats.Server.CurrentTime,
-		Tags:      []*MetricTag{memory_tag},
-	})
-	memoryMetrics = append(memoryMetrics, &Metric{
-		Name:      "ContextSize",
-		Value:     int64(xmlStats.Memory.Summary.ContextSize),
-		Timestamp: xmlStats.Server.CurrentTime,
-		Tags:      []*MetricTag{memory_tag},
-	})
-	memoryMetrics = append(memoryMetrics, &Metric{
-		Name:      "InUse",
-		Value:     int64(xmlStats.Memory.Summary.InUse),
-		Timestamp: xmlStats.Server.CurrentTime,
-		Tags:      []*MetricTag{memory_tag},
-	})
-	memoryMetrics = append(memoryMetrics, &Metric{
-		Name:      "Lost",
-		Value:     int64(xmlStats.Memory.Summary.Lost),
-		Timestamp: xmlStats.Server.CurrentTime,
-		Tags:      []*MetricTag{memory_tag},
-	})
-	memoryMetrics = append(memoryMetrics, &Metric{
-		Name:      "Malloced",
-		Value:     int64(xmlStats.Memory.Summary.Malloced),
-		Timestamp: xmlStats.Server.CurrentTime,
-		Tags:      []*MetricTag{memory_tag},
-	})
-	memoryMetrics = append(memoryMetrics, &Metric{
-		Name:      "TotalUse",
-		Value:     int64(xmlStats.Memory.Summary.TotalUse),
-		Timestamp: xmlStats.Server.CurrentTime,
-		Tags:      []*MetricTag{memory_tag},
-	})
-	returnMetrics = append(returnMetrics, memoryMetrics...)
+	// Process the memory statistics, which are missing when only some sections were read
+	if xmlStats.Memory.Summary != nil {
+		memory_tag := &MetricTag{"server", "memory"}
+		memoryMetrics := make([]*Metric, 0, 10)
+		memoryMetrics = append(memoryMetrics, &Metric{
+			Name:      "BlockSize",
+			Value:     int64(xmlStats.Memory.Summary.BlockSize),
+			Timestamp: xmlStats.Server.CurrentTime,
+			Tags:      []*MetricTag{memory_tag},
+		})
+		memoryMetrics = append(memoryMetrics, &Metric{
+			Name:      "ContextSize",
+			Value:     int64(xmlStats.Memory.Summary.ContextSize),
+			Timestamp: xmlStats.Server.CurrentTime,
+			Tags:      []*MetricTag{memory_tag},
+		})
+		memoryMetrics = append(memoryMetrics, &Metric{
+			Name:      "InUse",
+			Value:     int64(xmlStats.Memory.Summary.InUse),
+			Timestamp: xmlStats.Server.CurrentTime,
+			Tags:      []*MetricTag{memory_tag},
+		})
+		memoryMetrics = append(memoryMetrics, &Metric{
+			Name:      "Lost",
+			Value:     int64(xmlStats.Memory.Summary.Lost),
+			Timestamp: xmlStats.Server.CurrentTime,
+			Tags:      []*MetricTag{memory_tag},
+		})
+		memoryMetrics = append(memoryMetrics, &Metric{
+			Name:      "Malloced",
+			Value:     int64(xmlStats.Memory.Summary.Malloced),
+			Timestamp: xmlStats.Server.CurrentTime,
+			Tags:      []*MetricTag{memory_tag},
+		})
+		memoryMetrics = append(memoryMetrics, &Metric{
+			Name:      "TotalUse",
+			Value:     int64(xmlStats.Memory.Summary.TotalUse),
+			Timestamp: xmlStats.Server.CurrentTime,
+			Tags:      []*MetricTag{memory_tag},
+		})
+		returnMetrics = append(returnMetrics, memoryMetrics...)
+	}
 
 	// Process the socketmgr statistics
 	socketMetrics := make([]*Metric, 0, 10)
@@ -327,7 +371,7 @@ func ReadXmlStats(statsData []byte) error {
 					socket_metric.Tags,
 					&MetricTag{
 						"local-address",
-						strings.ReplaceAll(*socket.LocalAddress, ".", "_"),
+						*socket.LocalAddress,
 					},
 				)
 			}
@@ -336,7 +380,7 @@ func ReadXmlStats(statsData []byte) error {
 					socket_metric.Tags,
 					&MetricTag{
 						"peer-address",
-						strings.ReplaceAll(socket.PeerAddress, ".", "_"),
+						socket.PeerAddress,
 					},
 				)
 			}
@@ -423,40 +467,9 @@ func ReadXmlStats(statsData []byte) error {
 		for _, zone := range view.Zones.Zone {
 			zone_tags := make([]*MetricTag, 0, 10)
 			zone_tags = append(zone_tags, view_tag)
-			zonename := ""
-			if strings.Contains(strings.ToLower(zone.Name), "ip6.arpa") {
-				zone_name := zone.Name
-				zone_grps := make([]string, 0, 10)
-				if strings.Contains(zone_name, "IP6.ARPA") {
-					zone_grps = append(zone_grps, "IP6.ARPA")
-					zone_name = strings.Replace(zone_name, "IP6.ARPA", "", 1)
-				} else {
-					zone_grps = append(zone_grps, "ip6.arpa")
-					zone_name = strings.Replace(zone_name, "ip6.arpa", "", 1)
-				}
-				zone_name = strings.Trim(zone_name, ".")
-				zone_name = strings.ReplaceAll(zone_name, ".", "")
-				for {
-					if len(zone_name) < 4 {
-						if len(zone_name) > 0 {
-							zone_grps = append(zone_grps, zone_name)
-						}
-						break
-					}
-					zone_grp := zone_name[len(zone_name)-4:]
-					zone_grps = append(zone_grps, zone_grp)
-					zone_name = zone_name[:len(zone_name)-4]
-				}
-				for idx := len(zone_grps) - 1; idx >= 0; idx-- {
-					zonename = zonename + zone_grps[idx] + "."
-				}
-				zonename = zonename[:len(zonename)-1]
-			} else {
-				zonename = zone.Name
-			}
-			zone_tags = append(zone_tags, &MetricTag{"zone", strings.ReplaceAll(zonename, ".", "_")})
+			zone_tags = append(zone_tags, &MetricTag{"zone", zone.Name})
 			zone_tags = append(zone_tags, &MetricTag{"class", zone.Rdataclass})
-			zone_tags = append(zone_tags, &MetricTag{"type", zone.Type})
+			zone_tags = append(zone_tags, &MetricTag{"zone_type", zone.Type})
 			for _, zone_counter := range zone.Counters {
 				zone_counters := zone_counter.toMetrics(xmlStats.Server.CurrentTime)
 				for _, metric := range zone_counters {
@@ -473,7 +486,10 @@ func ReadXmlStats(statsData []byte) error {
 	}
 	returnMetrics = append(returnMetrics, viewMetrics...)
 
-	plugin.returnMetrics = returnMetrics
-
-	return nil
+	return &statistics{
+		Metrics:       returnMetrics,
+		BootTime:      xmlStats.Server.BootTime,
+		ConfigTime:    xmlStats.Server.ConfigTime,
+		ServerVersion: xmlStats.Server.Version,
+	}, nil
 }
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+// statisticsSections are the parts of the statistics channel that can be read
+// on their own, under the same name for both formats
+var statisticsSections = []string{"server", "zones", "net", "mem", "tasks", "traffic"}
+
+// statsTLSConfig is the TLS configuration for https statistics channels
+func statsTLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: plugin.InsecureSkipVerify}
+
+	if plugin.CAFile != "" {
+		caBundle, err := os.ReadFile(plugin.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read CA file: %s", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caBundle) {
+			return nil, fmt.Errorf("no certificates found in CA file: %s", plugin.CAFile)
+		}
+	}
+
+	if plugin.CertFile != "" || plugin.KeyFile != "" {
+		if plugin.CertFile == "" || plugin.KeyFile == "" {
+			return nil, fmt.Errorf("the client certificate needs both a cert file and a key file")
+		}
+		certificate, err := tls.LoadX509KeyPair(plugin.CertFile, plugin.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load client certificate: %s", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	return tlsConfig, nil
+}
+
+// readSecret returns the value, or the contents of the file when there is
+// one. Secret files are read on every request so rotated secrets get used.
+func readSecret(value, path string) (string, error) {
+	if path == "" {
+		return value, nil
+	}
+	secret, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(secret)), nil
+}
+
+// statsAuthorization is the Authorization header for the statistics channel,
+// empty when no credentials are configured.
+func statsAuthorization() (string, error) {
+	token, err := readSecret(plugin.BearerToken, plugin.BearerTokenFile)
+	if err != nil {
+		return "", fmt.Errorf("unable to read bearer token file: %s", err)
+	}
+	password, err := readSecret(plugin.Password, plugin.PasswordFile)
+	if err != nil {
+		return "", fmt.Errorf("unable to read password file: %s", err)
+	}
+
+	switch {
+	case token != "" && plugin.Username != "":
+		return "", fmt.Errorf("use either a bearer token or a username and password")
+	case token != "":
+		return "Bearer " + token, nil
+	case plugin.Username != "":
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(plugin.Username+":"+password)), nil
+	}
+	return "", nil
+}
+
+// seconds turns a timeout option into a duration
+func seconds(value float64) time.Duration {
+	return time.Duration(value * float64(time.Second))
+}
+
+// deadlineConn is a connection where every read times out when no data
+// arrives within the timeout, so a hung statistics listener is noticed.
+type deadlineConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (dc *deadlineConn) Read(b []byte) (int, error) {
+	if dc.timeout > 0 {
+		if err := dc.Conn.SetReadDeadline(time.Now().Add(dc.timeout)); err != nil {
+			return 0, err
+		}
+	}
+	return dc.Conn.Read(b)
+}
+
+// channelError is a failed statistics channel request, with the check state it
+// gives and whether trying again could help.
+type channelError struct {
+	Message string
+	State   int
+	Retry   bool
+	Err     error
+}
+
+func (ce *channelError) Error() string {
+	return fmt.Sprintf("statistics channel %s: %s", ce.Message, ce.Err)
+}
+
+func (ce *channelError) Unwrap() error {
+	return ce.Err
+}
+
+// isTimeout reports whether the error comes from one of the timeouts
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// fetchChannel makes one request to the statistics channel and reads the whole
+// response. A channel that is down or hangs is CRITICAL. A channel that answers
+// with a client error or cuts the response short is UNKNOWN, as the statistics
+// tell nothing about the health of BIND then.
+func fetchChannel(ctx context.Context, client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req.WithContext(ctx))
+	switch {
+	case err == nil:
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return nil, &channelError{"refused the connection", sensu.CheckStateCritical, true, err}
+	case isTimeout(err):
+		return nil, &channelError{"timed out", sensu.CheckStateCritical, true, err}
+	default:
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		statusErr := fmt.Errorf("%s", resp.Status)
+		if resp.StatusCode >= 500 {
+			return nil, &channelError{"returned a bad status", sensu.CheckStateCritical, true, statusErr}
+		}
+		return nil, &channelError{"returned a bad status", sensu.CheckStateUnknown, false, statusErr}
+	}
+
+	statsData, err := io.ReadAll(resp.Body)
+	switch {
+	case err == nil:
+		return statsData, nil
+	case isTimeout(err):
+		return nil, &channelError{"timed out", sensu.CheckStateCritical, true, err}
+	default:
+		return nil, &channelError{"sent a truncated body", sensu.CheckStateUnknown, true, err}
+	}
+}
+
+// fetchWithRetries retries the fetch on failures that could be transient,
+// doubling the wait between the attempts, until the context is done.
+func fetchWithRetries(ctx context.Context, fetch func(ctx context.Context) ([]byte, error)) ([]byte, error) {
+	backoff := seconds(plugin.RetryBackoff)
+	for attempt := 0; ; attempt++ {
+		statsData, err := fetch(ctx)
+		var ce *channelError
+		if err == nil || attempt >= plugin.Retries || !errors.As(err, &ce) || !ce.Retry {
+			return statsData, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
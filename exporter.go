@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	prometheusContentType  = "text/plain; version=0.0.4; charset=utf-8"
+	openMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+// exporter serves the metrics to Prometheus. The readers keep their results
+// in the plugin config, so scrapes are never run at the same time.
+type exporter struct {
+	mutex       sync.Mutex
+	interval    time.Duration
+	lastError   error
+	scraped     time.Time
+	prometheus  []byte
+	openMetrics []byte
+}
+
+func newExporter(interval time.Duration) *exporter {
+	return &exporter{interval: interval}
+}
+
+// scrape reads the statistics and renders them in both exposition formats
+func (e *exporter) scrape() error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.lastError = readStatistics()
+	if e.lastError != nil {
+		return e.lastError
+	}
+	// Report the targets that could not be read, the others are still served
+	e.lastError = errors.Join(plugin.targetErrors...)
+
+	prometheus := &bytes.Buffer{}
+	writeMetricsPrometheus(prometheus)
+	openMetrics := &bytes.Buffer{}
+	writeMetricsOpenMetrics(openMetrics)
+	e.prometheus = prometheus.Bytes()
+	e.openMetrics = openMetrics.Bytes()
+	e.scraped = time.Now()
+	return nil
+}
+
+// run scrapes the statistics on the interval, for serving from the cache
+func (e *exporter) run() {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		_ = e.scrape()
+	}
+}
+
+func (e *exporter) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	// Without an interval every request reads the statistics
+	if e.interval == 0 {
+		if err := e.scrape(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.prometheus == nil {
+		http.Error(w, fmt.Sprintf("no statistics read yet: %v", e.lastError), http.StatusServiceUnavailable)
+		return
+	}
+	// The metrics carry the time they were read, so they are not served again
+	// once the scrape that should have replaced them failed
+	if e.interval > 0 && e.lastError != nil && time.Since(e.scraped) > e.interval {
+		http.Error(w, fmt.Sprintf("statistics not read since %s: %v", e.scraped.Format(time.RFC3339), e.lastError), http.StatusServiceUnavailable)
+		return
+	}
+
+	// Prometheus asks for OpenMetrics when it supports it
+	if strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text") {
+		w.Header().Set("Content-Type", openMetricsContentType)
+		_, _ = w.Write(e.openMetrics)
+		return
+	}
+	w.Header().Set("Content-Type", prometheusContentType)
+	_, _ = w.Write(e.prometheus)
+}
+
+// serveHealthz reports whether the last scrape of the statistics worked
+func (e *exporter) serveHealthz(w http.ResponseWriter, r *http.Request) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.lastError != nil {
+		http.Error(w, e.lastError.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	_, _ = fmt.Fprintln(w, "OK")
+}
+
+func (e *exporter) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(plugin.MetricsPath, e.serveMetrics)
+	mux.HandleFunc(plugin.HealthzPath, e.serveHealthz)
+	return mux
+}
+
+// runExporter serves the metrics on the listen address until it fails
+func runExporter() error {
+	plugin.channelTimeout = time.Duration(plugin.ScrapeTimeout) * time.Second
+
+	e := newExporter(time.Duration(plugin.ScrapeInterval) * time.Second)
+	if e.interval > 0 {
+		// Fill the cache before the first request comes in
+		if err := e.scrape(); err != nil {
+			fmt.Fprintf(os.Stderr, "error scraping statistics: %s\n", err)
+		}
+		go e.run()
+	}
+
+	server := &http.Server{
+		Addr:              plugin.ListenAddress,
+		Handler:           e.handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return server.ListenAndServe()
+}
@@ -0,0 +1,89 @@
+package main
+
+import "slices"
+
+// gaugeRegistry lists, per counter group, the metrics that report a current
+// level rather than a running total since named started. The file reader
+// names are listed next to the JSON and XML names. A "*" marks a group that
+// only holds gauges. Every metric not listed here is a counter.
+var gaugeRegistry = map[string][]string{
+	// Memory summary and memory contexts, the totals are running totals
+	"memory": {"InUse", "Malloced", "BlockSize", "ContextSize"},
+	"context": {
+		"References", "InUse", "Maxinuse", "Malloced", "Maxmalloced",
+		"Blocksize", "Pools", "Hiwater", "Lowater",
+	},
+	// Socket references and queued task events
+	"socketmgr": {"*"},
+	"taskmgr":   {"*"},
+	"nsstat": {
+		"TCPConnHighWater", "RecursClients",
+		"TCP connection high-water", "recursing clients",
+	},
+	"sockstats": {
+		"UDP4Active", "UDP6Active", "TCP4Active", "TCP6Active", "UnixActive", "RawActive",
+		"UDP/IPv4 sockets active", "UDP/IPv6 sockets active", "TCP/IPv4 sockets active",
+		"TCP/IPv6 sockets active", "Unix domain sockets active", "Raw sockets active",
+	},
+	"resstats": {
+		"BucketSize", "QueryCurUDP", "QueryCurTCP", "NumFetch",
+		"bucket size", "UDP queries in progress", "TCP queries in progress", "active fetches",
+	},
+	"cachestats": {
+		"CacheNodes", "CacheBuckets", "TreeMemInUse", "TreeMemMax", "HeapMemInUse", "HeapMemMax",
+		"cache database nodes", "cache database hash buckets", "cache tree memory in use",
+		"cache tree highest memory in use", "cache heap memory in use", "cache heap highest memory in use",
+	},
+	// RRsets currently held in the cache
+	"cache": {"*"},
+	// Address database hash table sizes and entries
+	"adbstat": {"*"},
+}
+
+// group returns the counter group of the metric. The server tag names the
+// group for server wide metrics, otherwise the type tag does.
+func (m *Metric) group() string {
+	group := ""
+	for _, tag := range m.Tags {
+		if tag[0] == "server" {
+			return tag[1]
+		}
+		if tag[0] == "type" {
+			group = tag[1]
+		}
+	}
+	return group
+}
+
+// isGauge reports whether the metric can go down as well as up
+func (m *Metric) isGauge() bool {
+	gauges, ok := gaugeRegistry[m.group()]
+	if !ok {
+		return false
+	}
+	return slices.Contains(gauges, "*") || slices.Contains(gauges, m.Name)
+}
+
+// byteRegistry lists, per counter group, the metrics that report a number of
+// bytes. A "*" marks a group where every metric is in bytes.
+var byteRegistry = map[string][]string{
+	"memory": {"*"},
+	"context": {
+		"Total", "InUse", "Maxinuse", "Malloced", "Maxmalloced", "Blocksize", "Hiwater", "Lowater",
+	},
+	"cachestats": {
+		"TreeMemTotal", "TreeMemInUse", "TreeMemMax", "HeapMemTotal", "HeapMemInUse", "HeapMemMax",
+		"cache tree memory total", "cache tree memory in use", "cache tree highest memory in use",
+		"cache heap memory total", "cache heap memory in use", "cache heap highest memory in use",
+	},
+}
+
+// unit returns the unit of the metric value, or an empty string when the
+// value is a plain count.
+func (m *Metric) unit() string {
+	names := byteRegistry[m.group()]
+	if slices.Contains(names, "*") || slices.Contains(names, m.Name) {
+		return "bytes"
+	}
+	return ""
+}
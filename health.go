@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+var stateNames = map[int]string{
+	sensu.CheckStateOK:       "OK",
+	sensu.CheckStateWarning:  "WARNING",
+	sensu.CheckStateCritical: "CRITICAL",
+	sensu.CheckStateUnknown:  "UNKNOWN",
+}
+
+// counterSelector picks server counters out of the collected metrics. The
+// names list holds both the JSON/XML counter name and the description used in
+// the statistics file. An empty names list selects every counter in the group.
+type counterSelector struct {
+	Server string
+	Names  []string
+}
+
+func (cs *counterSelector) sum(metrics []*Metric) int64 {
+	var total int64
+	for _, metric := range metrics {
+		// Only the server wide counters, of every instance when there are several
+		tags := len(metric.Tags)
+		if slices.ContainsFunc(metric.Tags, func(tag *MetricTag) bool { return tag[0] == "instance" }) {
+			tags--
+		}
+		if tags != 1 || !contains(metric.Tags, "server", cs.Server) {
+			continue
+		}
+		if len(cs.Names) == 0 || slices.Contains(cs.Names, metric.Name) {
+			total += metric.ratioValue()
+		}
+	}
+	return total
+}
+
+// ratioValue is the change since the previous check run or dump when there is
+// one, so the ratios cover the recent queries instead of everything counted
+// since named started. A counter without a previous value is new, all of it
+// counts.
+func (m *Metric) ratioValue() int64 {
+	if m.Change != nil {
+		return m.Change.Delta
+	}
+	return m.Value
+}
+
+// healthRatio is a derived ratio between two sets of server counters that is
+// compared against the warning and critical thresholds.
+type healthRatio struct {
+	Name        string
+	Numerator   counterSelector
+	Denominator counterSelector
+	Warning     float64
+	Critical    float64
+}
+
+func healthRatios() []*healthRatio {
+	return []*healthRatio{
+		{
+			Name:        "SERVFAIL",
+			Numerator:   counterSelector{"rcodes", []string{"SERVFAIL"}},
+			Denominator: counterSelector{"rcodes", nil},
+			Warning:     plugin.ServfailWarning,
+			Critical:    plugin.ServfailCritical,
+		},
+		{
+			Name:        "NXDOMAIN",
+			Numerator:   counterSelector{"rcodes", []string{"NXDOMAIN"}},
+			Denominator: counterSelector{"rcodes", nil},
+			Warning:     plugin.NxdomainWarning,
+			Critical:    plugin.NxdomainCritical,
+		},
+		{
+			Name:        "query failure",
+			Numerator:   counterSelector{"nsstat", []string{"QryFailure", "other query failures"}},
+			Denominator: counterSelector{"nsstat", []string{"QrySuccess", "queries resulted in successful answer"}},
+			Warning:     plugin.QueryFailureWarning,
+			Critical:    plugin.QueryFailureCritical,
+		},
+		{
+			Name:      "recursion rejected",
+			Numerator: counterSelector{"nsstat", []string{"RecQryRej", "recursive queries rejected"}},
+			Denominator: counterSelector{"nsstat", []string{
+				"Requestv4", "Requestv6", "IPv4 requests received", "IPv6 requests received",
+			}},
+			Warning:  plugin.RecursionRejectedWarning,
+			Critical: plugin.RecursionRejectedCritical,
+		},
+	}
+}
+
+// percent returns the ratio as a percentage, a zero denominator gives zero.
+func (hr *healthRatio) percent(metrics []*Metric) float64 {
+	denominator := hr.Denominator.sum(metrics)
+	if denominator == 0 {
+		return 0
+	}
+	return float64(hr.Numerator.sum(metrics)) / float64(denominator) * 100
+}
+
+// validate checks that the thresholds are percentages and that the warning
+// comes before the critical threshold.
+func (hr *healthRatio) validate() error {
+	if hr.Warning < 0 || hr.Warning > 100 || hr.Critical < 0 || hr.Critical > 100 {
+		return fmt.Errorf("the %s thresholds must be between 0 and 100", hr.Name)
+	}
+	if hr.Warning > 0 && hr.Critical > 0 && hr.Warning > hr.Critical {
+		return fmt.Errorf("the %s warning threshold can not be above the critical threshold", hr.Name)
+	}
+	return nil
+}
+
+func (hr *healthRatio) enabled() bool {
+	return hr.Warning > 0 || hr.Critical > 0
+}
+
+// state returns the check state for the ratio and a short description of it
+func (hr *healthRatio) state(metrics []*Metric) (int, string) {
+	percent := hr.percent(metrics)
+	if hr.Critical > 0 && percent >= hr.Critical {
+		return sensu.CheckStateCritical, fmt.Sprintf("%s ratio %.2f%% >= %.2f%%", hr.Name, percent, hr.Critical)
+	}
+	if hr.Warning > 0 && percent >= hr.Warning {
+		return sensu.CheckStateWarning, fmt.Sprintf("%s ratio %.2f%% >= %.2f%%", hr.Name, percent, hr.Warning)
+	}
+	return sensu.CheckStateOK, fmt.Sprintf("%s ratio %.2f%%", hr.Name, percent)
+}
+
+// checkHealthRatios compares the configured ratios against their thresholds.
+// It returns the worst state found and a summary, or an empty summary when no
+// thresholds are configured.
+func checkHealthRatios(metrics []*Metric) (int, string) {
+	checkState := sensu.CheckStateOK
+	descriptions := make([]string, 0, 4)
+	for _, ratio := range healthRatios() {
+		if !ratio.enabled() {
+			continue
+		}
+		ratioState, description := ratio.state(metrics)
+		if ratioState > checkState {
+			checkState = ratioState
+		}
+		descriptions = append(descriptions, description)
+	}
+	if len(descriptions) == 0 {
+		return checkState, ""
+	}
+
+	return checkState, fmt.Sprintf("%s: %s", stateNames[checkState], strings.Join(descriptions, ", "))
+}
+
+// checkStaleness compares the age of the statistics file dump against the
+// thresholds. It returns an empty summary when there is no dump time or no
+// thresholds are configured.
+func checkStaleness(dumpTime time.Time, now time.Time) (int, string) {
+	if dumpTime.IsZero() || (plugin.MaxAgeWarning <= 0 && plugin.MaxAgeCritical <= 0) {
+		return sensu.CheckStateOK, ""
+	}
+
+	age := now.Sub(dumpTime).Minutes()
+	checkState := sensu.CheckStateOK
+	description := fmt.Sprintf("statistics dump age %.1f minutes", age)
+	if plugin.MaxAgeCritical > 0 && age >= plugin.MaxAgeCritical {
+		checkState = sensu.CheckStateCritical
+		description += fmt.Sprintf(" >= %g minutes", plugin.MaxAgeCritical)
+	} else if plugin.MaxAgeWarning > 0 && age >= plugin.MaxAgeWarning {
+		checkState = sensu.CheckStateWarning
+		description += fmt.Sprintf(" >= %g minutes", plugin.MaxAgeWarning)
+	}
+	return checkState, fmt.Sprintf("%s: %s", stateNames[checkState], description)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// promBucket is a bucket of a bucketed distribution reported by BIND. The
+// names list holds both the JSON/XML counter name and the description used in
+// the statistics file.
+type promBucket struct {
+	Names []string
+	Lower float64
+	Upper float64
+}
+
+// promHistogramFamily is the histogram family a bucketed distribution is
+// reported under. Distributions without a fixed list of buckets have their
+// bounds parsed from the counter names.
+type promHistogramFamily struct {
+	Name    string
+	Unit    string
+	Buckets []promBucket
+}
+
+// promHistogramFamilies maps the counter groups holding bucketed
+// distributions onto histogram families.
+var promHistogramFamilies = map[string]promHistogramFamily{
+	// Resolver query round trip times, reported in milliseconds
+	"resstats": {"bind_resolver_query_rtt", "seconds", []promBucket{
+		{[]string{"QryRTT10", "queries with RTT < 10ms"}, 0, 0.01},
+		{[]string{"QryRTT100", "queries with RTT 10-100ms"}, 0.01, 0.1},
+		{[]string{"QryRTT500", "queries with RTT 100-500ms"}, 0.1, 0.5},
+		{[]string{"QryRTT800", "queries with RTT 500-800ms"}, 0.5, 0.8},
+		{[]string{"QryRTT1600", "queries with RTT 800-1600ms"}, 0.8, 1.6},
+		{[]string{"QryRTT1600+", "queries with RTT > 1600ms"}, 1.6, math.Inf(1)},
+	}},
+	// Message sizes, BIND only reports the buckets that have been used
+	"request-size":  {"bind_traffic_request_size", "bytes", nil},
+	"response-size": {"bind_traffic_response_size", "bytes", nil},
+}
+
+// bounds returns the bounds of the bucket the counter counts
+func (phf *promHistogramFamily) bounds(name string) (float64, float64, bool) {
+	if phf.Buckets != nil {
+		for _, bucket := range phf.Buckets {
+			if slices.Contains(bucket.Names, name) {
+				return bucket.Lower, bucket.Upper, true
+			}
+		}
+		return 0, 0, false
+	}
+
+	// Sizes are reported as "16-31" ranges, and a final "4096+" bucket. The
+	// XML reader names the UDP ranges "range16-31".
+	name = strings.TrimPrefix(name, "range")
+	if lower_text, found := strings.CutSuffix(name, "+"); found {
+		lower, err := strconv.ParseFloat(lower_text, 64)
+		return lower, math.Inf(1), err == nil
+	}
+	lower_text, upper_text, found := strings.Cut(name, "-")
+	if !found {
+		return 0, 0, false
+	}
+	lower, err := strconv.ParseFloat(lower_text, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	upper, err := strconv.ParseFloat(upper_text, 64)
+	return lower, upper, err == nil
+}
+
+type PrometheusHistogramBucket struct {
+	Lower float64
+	Upper float64
+	Count int64
+}
+
+type PrometheusHistogram struct {
+	Name      string
+	Unit      string
+	Label     []*PromLabel
+	Timestamp time.Time
+	Buckets   []*PrometheusHistogramBucket
+}
+
+func (ph *PrometheusHistogram) count() int64 {
+	var count int64
+	for _, bucket := range ph.Buckets {
+		count += bucket.Count
+	}
+	return count
+}
+
+// sum estimates the sum of the observations from the middle of every bucket,
+// the open ended bucket counts at its lower bound.
+func (ph *PrometheusHistogram) sum() float64 {
+	var sum float64
+	for _, bucket := range ph.Buckets {
+		if math.IsInf(bucket.Upper, 1) {
+			sum += bucket.Lower * float64(bucket.Count)
+			continue
+		}
+		sum += (bucket.Lower + bucket.Upper) / 2 * float64(bucket.Count)
+	}
+	return sum
+}
+
+// addToHistogram adds the metric to its histogram when it is a bucket of a
+// bucketed distribution, and reports whether it was.
+func (pmg *PrometheusMetricGroups) addToHistogram(metric *Metric) bool {
+	family, ok := promHistogramFamilies[metric.group()]
+	if !ok {
+		return false
+	}
+	lower, upper, ok := family.bounds(metric.Name)
+	if !ok {
+		return false
+	}
+
+	name := family.Name + "_" + family.Unit
+	labels := promLabels(metric, "")
+	var histogram *PrometheusHistogram
+	for _, ph := range pmg.Histograms {
+		if ph.Name == name && promLabelsToString(ph.Label) == promLabelsToString(labels) {
+			histogram = ph
+			break
+		}
+	}
+	if histogram == nil {
+		histogram = &PrometheusHistogram{Name: name, Unit: family.Unit, Label: labels, Timestamp: metric.Timestamp}
+		// Buckets with no observations are not reported, so start from the full list
+		for _, bucket := range family.Buckets {
+			histogram.Buckets = append(histogram.Buckets, &PrometheusHistogramBucket{Lower: bucket.Lower, Upper: bucket.Upper})
+		}
+		pmg.Histograms = append(pmg.Histograms, histogram)
+	}
+
+	for _, bucket := range histogram.Buckets {
+		if bucket.Upper == upper {
+			bucket.Count += metric.Value
+			return true
+		}
+	}
+	histogram.Buckets = append(histogram.Buckets, &PrometheusHistogramBucket{Lower: lower, Upper: upper, Count: metric.Value})
+	sort.Slice(histogram.Buckets, func(i, j int) bool {
+		return histogram.Buckets[i].Upper < histogram.Buckets[j].Upper
+	})
+	return true
+}
+
+func promFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// histogramFamilies splits the sorted histograms into their families
+func (pmg *PrometheusMetricGroups) histogramFamilies() [][]*PrometheusHistogram {
+	families := make([][]*PrometheusHistogram, 0)
+	for idx, histogram := range pmg.Histograms {
+		if idx == 0 || pmg.Histograms[idx-1].Name != histogram.Name {
+			families = append(families, []*PrometheusHistogram{})
+		}
+		families[len(families)-1] = append(families[len(families)-1], histogram)
+	}
+	return families
+}
+
+// writeHistogramFamily writes the histograms of a family with cumulative
+// buckets, OpenMetrics adds the unit and the _created samples.
+func writeHistogramFamily(w io.Writer, histograms []*PrometheusHistogram, timestamp func(time.Time) string, open_metrics bool) {
+	name := histograms[0].Name
+	if open_metrics {
+		fmt.Fprintln(w, "# TYPE "+name+" histogram")
+		fmt.Fprintln(w, "# UNIT "+name+" "+histograms[0].Unit)
+		fmt.Fprintln(w, "# HELP "+name+" Bind DNS statistics")
+	} else {
+		fmt.Fprintln(w, "# HELP "+name+" Bind DNS statistics")
+		fmt.Fprintln(w, "# TYPE "+name+" histogram")
+	}
+
+	for _, histogram := range histograms {
+		labels := promLabelsToString(histogram.Label)
+		bucket_labels := labels
+		if bucket_labels != "" {
+			bucket_labels += ","
+		}
+		metric_time := timestamp(histogram.Timestamp)
+		var cumulative int64
+		for _, bucket := range histogram.Buckets {
+			cumulative += bucket.Count
+			if !math.IsInf(bucket.Upper, 1) {
+				fmt.Fprintf(w, "%s_bucket{%sle=\"%s\"} %d %s\n", name, bucket_labels, promFloat(bucket.Upper), cumulative, metric_time)
+			}
+		}
+		fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d %s\n", name, bucket_labels, histogram.count(), metric_time)
+		fmt.Fprintf(w, "%s_count{%s} %d %s\n", name, labels, histogram.count(), metric_time)
+		fmt.Fprintf(w, "%s_sum{%s} %s %s\n", name, labels, promFloat(histogram.sum()), metric_time)
+		if created := createdTime(histogram.Label); open_metrics && !created.IsZero() {
+			fmt.Fprintf(w, "%s_created{%s} %s %s\n", name, labels, openMetricsTimestamp(created), metric_time)
+		}
+	}
+}
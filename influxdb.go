@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "\n", `\n`)
+	influxTagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", `\n`)
+)
+
+// influxTags renders the metric tags as an InfluxDB tag set
+func (m *Metric) influxTags() string {
+	tags := make([]string, 0, len(m.Tags))
+	for _, tag := range m.valuedTags() {
+		tags = append(tags, influxTagEscaper.Replace(tag[0])+"="+influxTagEscaper.Replace(tag[1]))
+	}
+	// InfluxDB recommends sorting the tags by key
+	sort.Strings(tags)
+	return strings.Join(tags, ",")
+}
+
+// InfluxDB renders the metric in the InfluxDB line protocol
+func (m *Metric) InfluxDB() string {
+	measurement := influxMeasurementEscaper.Replace(m.Name)
+	if tags := m.influxTags(); tags != "" {
+		measurement += "," + tags
+	}
+
+	fields := []string{fmt.Sprintf("value=%di", m.Value)}
+	if m.Change != nil {
+		fields = append(fields, fmt.Sprintf("delta=%di", m.Change.Delta))
+		fields = append(fields, "rate="+strconv.FormatFloat(m.Change.Rate, 'f', -1, 64))
+	}
+
+	return fmt.Sprintf("%s %s %d", measurement, strings.Join(fields, ","), m.Timestamp.UnixNano())
+}
+
+func OutputMetricsInfluxDB() {
+	// Output metrics in InfluxDB line protocol
+	for _, metric := range plugin.returnMetrics {
+		fmt.Println(metric.InfluxDB())
+	}
+}
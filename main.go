@@ -1,15 +1,18 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
-	"io"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	v2 "github.com/sensu/core/v2"
@@ -23,8 +26,70 @@ type Config struct {
 	StatisticsFilePath string
 	StatisticsIP       string
 	StatisticsPort     int
-	OutputFormat       string
-	returnMetrics      []*Metric
+	AddressFamily      string
+	StatisticsSections []string
+	ParallelSections   bool
+	DumpDeltas         bool
+	RndcCommand        string
+	RndcTimeout        float64
+	FileRotation       string
+	UnparsedLines      string
+	// Statistics channel TLS and authentication
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	InsecureSkipVerify bool
+	Username           string
+	Password           string
+	PasswordFile       string
+	BearerToken        string
+	BearerTokenFile    string
+	// Statistics channel timeouts, in seconds, and retries
+	ConnectTimeout float64
+	ReadTimeout    float64
+	Timeout        float64
+	Retries        int
+	RetryBackoff   float64
+	OutputFormat   string
+	Targets        []string
+	Concurrency    int
+	targets        []*target
+	targetErrors   []error
+	// Thresholds for the age of the statistics file dump, in minutes
+	MaxAgeWarning  float64
+	MaxAgeCritical float64
+	// Thresholds for the derived ratios, as percentages
+	ServfailWarning           float64
+	ServfailCritical          float64
+	NxdomainWarning           float64
+	NxdomainCritical          float64
+	QueryFailureWarning       float64
+	QueryFailureCritical      float64
+	RecursionRejectedWarning  float64
+	RecursionRejectedCritical float64
+	StateFilePath             string
+	GraphitePrefix            string
+	EntityName                string
+	GraphiteTemplate          []string
+	// Prometheus exporter mode
+	ListenAddress  string
+	MetricsPath    string
+	HealthzPath    string
+	ScrapeInterval int
+	ScrapeTimeout  int
+	channelTimeout time.Duration
+	returnMetrics  []*Metric
+	bootTime       time.Time
+	configTime     time.Time
+	serverVersion  string
+	dumpTime       time.Time
+	unparsedLines  []*unparsedLine
+
+	// instanceBootTimes are the boot times of the targets when there are several
+	instanceBootTimes map[string]time.Time
+
+	// dumpRequest is the rndc command run of the current check
+	dumpRequest *statisticsDumpRequest
 }
 
 var (
@@ -55,15 +120,63 @@ var (
 			Usage:     "The file path to the statistics file",
 			Value:     &plugin.StatisticsFilePath,
 		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "dump-deltas",
+			Env:      "DUMP_DELTAS",
+			Argument: "dump-deltas",
+			Default:  false,
+			Usage:    "Report the change of every metric between the last two dumps in the statistics file",
+			Value:    &plugin.DumpDeltas,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "rndc-command",
+			Env:      "RNDC_COMMAND",
+			Argument: "rndc-command",
+			Default:  "",
+			Usage:    "Command that makes named write a statistics dump (e.g. \"rndc stats\"), the check waits for the new dump and reads only that",
+			Value:    &plugin.RndcCommand,
+		},
+		&sensu.PluginConfigOption[float64]{
+			Path:     "rndc-timeout",
+			Env:      "RNDC_TIMEOUT",
+			Argument: "rndc-timeout",
+			Default:  10,
+			Usage:    "Seconds to wait for the rndc command and the new statistics dump",
+			Value:    &plugin.RndcTimeout,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "file-rotation",
+			Env:      "FILE_ROTATION",
+			Argument: "file-rotation",
+			Default:  "none",
+			Usage:    "What to do with the statistics file after reading a new dump (none, truncate, or rotate to a .1 file, which replaces the .1 file of the previous run)",
+			Value:    &plugin.FileRotation,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "unparsed-lines",
+			Env:      "UNPARSED_LINES",
+			Argument: "unparsed-lines",
+			Default:  "ignore",
+			Usage:    "What to do with statistics file lines the parser does not recognize (ignore, report them, or fail to report them with an UNKNOWN result)",
+			Value:    &plugin.UnparsedLines,
+		},
 		&sensu.PluginConfigOption[string]{
 			Path:      "statistics-ip",
 			Env:       "STATISTICS_IP",
 			Argument:  "statistics-ip",
 			Shorthand: "a",
 			Default:   "",
-			Usage:     "The IP address to listen on for the statistics channel",
+			Usage:     "The IP address or hostname of the statistics channel, optionally with a port, its URL, or its Unix socket as unix:/path",
 			Value:     &plugin.StatisticsIP,
 		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "address-family",
+			Env:      "ADDRESS_FAMILY",
+			Argument: "address-family",
+			Default:  "any",
+			Usage:    "The addresses of the statistics channel host to use (any, ipv4, ipv6, prefer-ipv4, prefer-ipv6)",
+			Value:    &plugin.AddressFamily,
+		},
 		&sensu.PluginConfigOption[int]{
 			Path:      "statistics-port",
 			Env:       "STATISTICS_PORT",
@@ -73,22 +186,368 @@ var (
 			Usage:     "The port to listen on for the statistics channel",
 			Value:     &plugin.StatisticsPort,
 		},
+		&sensu.SlicePluginConfigOption[string]{
+			Path:     "statistics-sections",
+			Env:      "STATISTICS_SECTIONS",
+			Argument: "statistics-sections",
+			Default:  []string{},
+			Usage:    "Only read these sections of the statistics channel (server, zones, net, mem, tasks, traffic), instead of everything",
+			Value:    &plugin.StatisticsSections,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "parallel-sections",
+			Env:      "PARALLEL_SECTIONS",
+			Argument: "parallel-sections",
+			Default:  false,
+			Usage:    "Read the statistics channel sections at the same time",
+			Value:    &plugin.ParallelSections,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "ca-file",
+			Env:      "STATISTICS_CA_FILE",
+			Argument: "ca-file",
+			Default:  "",
+			Usage:    "The CA bundle to verify https statistics channels with, instead of the system CAs",
+			Value:    &plugin.CAFile,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "cert-file",
+			Env:      "STATISTICS_CERT_FILE",
+			Argument: "cert-file",
+			Default:  "",
+			Usage:    "The client certificate for https statistics channels",
+			Value:    &plugin.CertFile,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "key-file",
+			Env:      "STATISTICS_KEY_FILE",
+			Argument: "key-file",
+			Default:  "",
+			Usage:    "The key of the client certificate for https statistics channels",
+			Value:    &plugin.KeyFile,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:     "insecure-skip-verify",
+			Env:      "STATISTICS_INSECURE_SKIP_VERIFY",
+			Argument: "insecure-skip-verify",
+			Default:  false,
+			Usage:    "Do not verify the certificate of https statistics channels",
+			Value:    &plugin.InsecureSkipVerify,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "username",
+			Env:      "STATISTICS_USERNAME",
+			Argument: "username",
+			Default:  "",
+			Usage:    "The username for basic authentication to the statistics channel",
+			Value:    &plugin.Username,
+		},
+		&sensu.PluginConfigOption[string]{
+			Env:      "STATISTICS_PASSWORD",
+			Argument: "password",
+			Default:  "",
+			Secret:   true,
+			Usage:    "The password for basic authentication to the statistics channel",
+			Value:    &plugin.Password,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "password-file",
+			Env:      "STATISTICS_PASSWORD_FILE",
+			Argument: "password-file",
+			Default:  "",
+			Usage:    "A file holding the password for basic authentication to the statistics channel",
+			Value:    &plugin.PasswordFile,
+		},
+		&sensu.PluginConfigOption[string]{
+			Env:      "STATISTICS_BEARER_TOKEN",
+			Argument: "bearer-token",
+			Default:  "",
+			Secret:   true,
+			Usage:    "The bearer token for authentication to the statistics channel",
+			Value:    &plugin.BearerToken,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "bearer-token-file",
+			Env:      "STATISTICS_BEARER_TOKEN_FILE",
+			Argument: "bearer-token-file",
+			Default:  "",
+			Usage:    "A file holding the bearer token for authentication to the statistics channel",
+			Value:    &plugin.BearerTokenFile,
+		},
+		&sensu.PluginConfigOption[float64]{
+			Path:     "connect-timeout",
+			Env:      "STATISTICS_CONNECT_TIMEOUT",
+			Argument: "connect-timeout",
+			Default:  5,
+			Usage:    "Seconds to wait for the statistics channel to accept the connection (0 waits forever)",
+			Value:    &plugin.ConnectTimeout,
+		},
+		&sensu.PluginConfigOption[float64]{
+			Path:     "read-timeout",
+			Env:      "STATISTICS_READ_TIMEOUT",
+			Argument: "read-timeout",
+			Default:  10,
+			Usage:    "Seconds to wait for the statistics channel to send more of the response (0 waits forever)",
+			Value:    &plugin.ReadTimeout,
+		},
+		&sensu.PluginConfigOption[float64]{
+			Path:     "timeout",
+			Env:      "STATISTICS_TIMEOUT",
+			Argument: "timeout",
+			Default:  30,
+			Usage:    "Seconds reading the statistics channel may take in all, retries included (0 waits forever)",
+			Value:    &plugin.Timeout,
+		},
+		&sensu.PluginConfigOption[int]{
+			Path:     "retries",
+			Env:      "STATISTICS_RETRIES",
+			Argument: "retries",
+			Default:  2,
+			Usage:    "Number of times to retry a statistics channel request that was refused, timed out, got a server error or got cut short",
+			Value:    &plugin.Retries,
+		},
+		&sensu.PluginConfigOption[float64]{
+			Path:     "retry-backoff",
+			Env:      "STATISTICS_RETRY_BACKOFF",
+			Argument: "retry-backoff",
+			Default:  0.5,
+			Usage:    "Seconds to wait before the first retry, doubled for every retry after it",
+			Value:    &plugin.RetryBackoff,
+		},
+		&sensu.SlicePluginConfigOption[string]{
+			Path:      "target",
+			Env:       "STATISTICS_TARGETS",
+			Argument:  "target",
+			Shorthand: "t",
+			Default:   []string{},
+			Usage:     "A BIND server to read, as format:address (e.g. json:192.0.2.1:8053 or file:/var/named/named.stats), can be repeated instead of the statistics options",
+			Value:     &plugin.Targets,
+		},
+		&sensu.PluginConfigOption[int]{
+			Path:     "concurrency",
+			Env:      "STATISTICS_CONCURRENCY",
+			Argument: "concurrency",
+			Default:  4,
+			Usage:    "The number of targets to read at the same time",
+			Value:    &plugin.Concurrency,
+		},
 		&sensu.PluginConfigOption[string]{
 			Path:      "output-format",
 			Env:       "OUTPUT_FORMAT",
 			Argument:  "output-format",
 			Shorthand: "o",
 			Default:   "",
-			Usage:     "The format to output the metrics in (graphite, prometheus)",
+			Usage:     "The format to output the metrics in (graphite, graphite-tagged, influxdb, openmetrics, prometheus, sensu)",
 			Value:     &plugin.OutputFormat,
 		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "graphite-prefix",
+			Env:      "GRAPHITE_PREFIX",
+			Argument: "graphite-prefix",
+			Default:  "bind.dns",
+			Usage:    "The prefix for the Graphite metric paths, {hostname} and {entity} are replaced with the host and entity name",
+			Value:    &plugin.GraphitePrefix,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "entity-name",
+			Env:      "CHECK_ENTITY_NAME",
+			Argument: "entity-name",
+			Default:  "",
+			Usage:    "The entity name for the {entity} placeholder, usually set with {{ .name }} token substitution (defaults to the host name)",
+			Value:    &plugin.EntityName,
+		},
+		&sensu.SlicePluginConfigOption[string]{
+			Path:     "graphite-template",
+			Env:      "GRAPHITE_TEMPLATE",
+			Argument: "graphite-template",
+			Default:  []string{},
+			Usage:    "The tags that make up the Graphite metric path, in order, * stands for the remaining tags (e.g. server,view,zone,type)",
+			Value:    &plugin.GraphiteTemplate,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:      "state-file",
+			Env:       "STATE_FILE",
+			Argument:  "state-file",
+			Shorthand: "s",
+			Default:   "",
+			Usage:     "File to keep the previous values in, enables the delta and rate metrics",
+			Value:     &plugin.StateFilePath,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "listen-address",
+			Env:      "LISTEN_ADDRESS",
+			Argument: "listen-address",
+			Default:  "",
+			Usage:    "Run as a Prometheus exporter listening on this address (e.g. :9119) instead of as a check",
+			Value:    &plugin.ListenAddress,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "metrics-path",
+			Env:      "METRICS_PATH",
+			Argument: "metrics-path",
+			Default:  "/metrics",
+			Usage:    "The path the exporter serves the metrics on",
+			Value:    &plugin.MetricsPath,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:     "healthz-path",
+			Env:      "HEALTHZ_PATH",
+			Argument: "healthz-path",
+			Default:  "/healthz",
+			Usage:    "The path the exporter serves the health of the last scrape on",
+			Value:    &plugin.HealthzPath,
+		},
+		&sensu.PluginConfigOption[int]{
+			Path:     "scrape-interval",
+			Env:      "SCRAPE_INTERVAL",
+			Argument: "scrape-interval",
+			Default:  0,
+			Usage:    "Seconds between exporter scrapes of the statistics, served from a cache (0 scrapes on every request)",
+			Value:    &plugin.ScrapeInterval,
+		},
+		&sensu.PluginConfigOption[int]{
+			Path:     "scrape-timeout",
+			Env:      "SCRAPE_TIMEOUT",
+			Argument: "scrape-timeout",
+			Default:  10,
+			Usage:    "Seconds the exporter waits for the statistics channel on each scrape",
+			Value:    &plugin.ScrapeTimeout,
+		},
+		&sensu.PluginConfigOption[float64]{
+			Path:     "servfail-warning",
+			Env:      "SERVFAIL_WARNING",
+			Argument: "servfail-warning",
+			Default:  0,
+			Usage:    "Warning threshold for SERVFAIL responses as a percentage of all responses (0 disables)",
+			Value:    &plugin.ServfailWarning,
+		},
+		&sensu.PluginConfigOption[float64]{
+			Path:     "servfail-critical",
+			Env:      "SERVFAIL_CRITICAL",
+			Argument: "servfail-critical",
+			Default:  0,
+			Usage:    "Critical threshold for SERVFAIL responses as a percentage of all responses (0 disables)",
+			Value:    &plugin.ServfailCritical,
+		},
+		&sensu.PluginConfigOption[float64]{
+			Path:     "nxdomain-warning",
+			Env:      "NXDOMAIN_WARNING",
+			Argument: "nxdomain-warning",
+			Default:  0,
+			Usage:    "Warning threshold for NXDOMAIN responses as a percentage of all responses (0 disables)",
+			Value:    &plugin.NxdomainWarning,
+		},
+		&sensu.PluginConfigOption[float64]{
+			Path:     "nxdomain-critical",
+			Env:      "NXDOMAIN_CRITICAL",
+			Argument: "nxdomain-critical",
+			Default:  0,
+			Usage:    "Critical threshold for NXDOMAIN responses as a percentage of all responses (0 disables)",
+			Value:    &plugin.NxdomainCritical,
+		},
+		&sensu.PluginConfigOption[float64]{
+			Path:     "query-failure-warning",
+			Env:      "QUERY_FAILURE_WARNING",
+			Argument: "query-failure-warning",
+			Default:  0,
+			Usage:    "Warning threshold for Failed queries as a percentage of successful queries (0 disables)",
+			Value:    &plugin.QueryFailureWarning,
+		},
+		&sensu.PluginConfigOption[float64]{
+			Path:     "query-failure-critical",
+			Env:      "QUERY_FAILURE_CRITICAL",
+			Argument: "query-failure-critical",
+			Default:  0,
+			Usage:    "Critical threshold for Failed queries as a percentage of successful queries (0 disables)",
+			Value:    &plugin.QueryFailureCritical,
+		},
+		&sensu.PluginConfigOption[float64]{
+			Path:     "recursion-rejected-warning",
+			Env:      "RECURSION_REJECTED_WARNING",
+			Argument: "recursion-rejected-warning",
+			Default:  0,
+			Usage:    "Warning threshold for Rejected recursive queries as a percentage of all requests (0 disables)",
+			Value:    &plugin.RecursionRejectedWarning,
+		},
+		&sensu.PluginConfigOption[float64]{
+			Path:     "recursion-rejected-critical",
+			Env:      "RECURSION_REJECTED_CRITICAL",
+			Argument: "recursion-rejected-critical",
+			Default:  0,
+			Usage:    "Critical threshold for Rejected recursive queries as a percentage of all requests (0 disables)",
+			Value:    &plugin.RecursionRejectedCritical,
+		},
+		&sensu.PluginConfigOption[float64]{
+			Path:     "max-age-warning",
+			Env:      "MAX_AGE_WARNING",
+			Argument: "max-age-warning",
+			Default:  0,
+			Usage:    "Warning threshold for the age of the statistics file dump in minutes (0 disables)",
+			Value:    &plugin.MaxAgeWarning,
+		},
+		&sensu.PluginConfigOption[float64]{
+			Path:     "max-age-critical",
+			Env:      "MAX_AGE_CRITICAL",
+			Argument: "max-age-critical",
+			Default:  0,
+			Usage:    "Critical threshold for the age of the statistics file dump in minutes (0 disables)",
+			Value:    &plugin.MaxAgeCritical,
+		},
 	}
 )
 
 type MetricTag [2]string
 
 func (mt *MetricTag) String() string {
-	return fmt.Sprintf("%s_%s", mt[0], mt[1])
+	return fmt.Sprintf("%s_%s", mt[0], mt.pathValue())
+}
+
+// pathValue returns the tag value made safe for use as a single component of
+// a dotted metric path. IP6.ARPA zone names are shortened into groups of four
+// nibbles first, as they would otherwise be very long.
+func (mt *MetricTag) pathValue() string {
+	value := mt[1]
+	if mt[0] == "zone" {
+		value = compactIp6Zone(value)
+	}
+	return strings.ReplaceAll(value, ".", "_")
+}
+
+// compactIp6Zone groups the nibbles of an IP6.ARPA zone name into groups of
+// four, so 8.B.D.0.1.0.0.2.IP6.ARPA becomes 8BD0.1002.IP6.ARPA. Other zone
+// names are returned unchanged.
+func compactIp6Zone(zone string) string {
+	if !strings.Contains(strings.ToLower(zone), "ip6.arpa") {
+		return zone
+	}
+
+	zone_grps := make([]string, 0, 10)
+	zone_name := zone
+	if strings.Contains(zone_name, "IP6.ARPA") {
+		zone_name = strings.Replace(zone_name, "IP6.ARPA", "", 1)
+		zone_grps = append(zone_grps, "IP6.ARPA")
+	} else {
+		zone_name = strings.Replace(zone_name, "ip6.arpa", "", 1)
+		zone_grps = append(zone_grps, "ip6.arpa")
+	}
+	zone_name = strings.Trim(zone_name, ".")
+	zone_name = strings.ReplaceAll(zone_name, ".", "")
+	for {
+		if len(zone_name) < 4 {
+			if len(zone_name) > 0 {
+				zone_grps = append(zone_grps, zone_name)
+			}
+			break
+		}
+		zone_grp := zone_name[len(zone_name)-4:]
+		zone_grps = append(zone_grps, zone_grp)
+		zone_name = zone_name[:len(zone_name)-4]
+	}
+	zonename := ""
+	for idx := len(zone_grps) - 1; idx >= 0; idx-- {
+		zonename = zonename + zone_grps[idx] + "."
+	}
+	return zonename[:len(zonename)-1]
 }
 
 type Metric struct {
@@ -96,112 +555,402 @@ type Metric struct {
 	Value     int64
 	Timestamp time.Time
 	Tags      []*MetricTag
+	// Change is set when the previous value was loaded from the state file
+	Change *MetricChange
 }
 
 type namedStats struct {
-	statsTags []*MetricTag
-	curLevel  string
+	statsTags  []*MetricTag
+	curLevel   string
+	sectionTag *MetricTag
+	// unknownSection is set in a section the parser does not recognize
+	unknownSection bool
 }
 
-func (m *Metric) Graphite(tag_prefix string) string {
+// setTags replaces the tags for the metrics that follow, the section tag is
+// always kept last so the file metrics are tagged like the JSON and XML ones.
+func (ns *namedStats) setTags(tags ...*MetricTag) {
+	ns.statsTags = make([]*MetricTag, 0, len(tags)+1)
+	ns.statsTags = append(ns.statsTags, tags...)
+	if ns.sectionTag != nil {
+		ns.statsTags = append(ns.statsTags, ns.sectionTag)
+	}
+}
+
+// context describes where in the statistics file the parser is
+func (ns *namedStats) context() string {
+	if ns.curLevel == "" {
+		return "before the first section"
+	}
+	context := []string{ns.curLevel}
+	for _, tag := range ns.statsTags {
+		if tag != ns.sectionTag {
+			context = append(context, tag[0]+"="+tag[1])
+		}
+	}
+	return strings.Join(context, " ")
+}
+
+func (m *Metric) graphitePath(tag_prefix string) string {
 	var tags []string
 	if tag_prefix != "" {
 		tags = append(tags, tag_prefix)
 	}
-	for _, tag := range m.Tags {
+	for _, tag := range m.templateTags(plugin.GraphiteTemplate) {
 		tags = append(tags, tag.String())
 	}
+	tags = append(tags, strings.ReplaceAll(m.Name, " ", "_"))
+
+	return strings.Join(tags, ".")
+}
+
+// templateTags orders the tags by the tag names listed in the template, a
+// "*" in the template stands for all the tags not listed, in reader order.
+// Tags that are not listed are left out. An empty template keeps every tag.
+func (m *Metric) templateTags(template []string) []*MetricTag {
+	if len(template) == 0 {
+		return m.Tags
+	}
+
+	tags := make([]*MetricTag, 0, len(m.Tags))
+	for _, tag_name := range template {
+		for _, tag := range m.Tags {
+			if tag[0] == tag_name || (tag_name == "*" && !slices.Contains(template, tag[0])) {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
 
-	return fmt.Sprintf(
-		"%s.%s %d %d",
-		strings.Join(tags, "."),
-		strings.ReplaceAll(m.Name, " ", "_"),
-		m.Value,
-		m.Timestamp.Unix(),
-	)
+// graphitePrefix expands the {hostname} and {entity} placeholders in the
+// configured Graphite prefix. A check gets no event from the agent, so the
+// entity name comes from the entity name option and falls back to the host
+// name.
+func graphitePrefix() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	entity := hostname
+	if plugin.EntityName != "" {
+		entity = plugin.EntityName
+	}
+
+	prefix := strings.NewReplacer(
+		"{hostname}", strings.ReplaceAll(hostname, ".", "_"),
+		"{entity}", strings.ReplaceAll(entity, ".", "_"),
+	).Replace(plugin.GraphitePrefix)
+	return strings.Trim(prefix, ".")
+}
+
+func (m *Metric) Graphite(tag_prefix string) string {
+	return fmt.Sprintf("%s %d %d", m.graphitePath(tag_prefix), m.Value, m.Timestamp.Unix())
+}
+
+var graphiteTagEscaper = strings.NewReplacer(";", "_", " ", "_", "=", "_", "!", "_", "^", "_", "~", "_")
+
+// valuedTags returns the tags that have both a name and a value, for the
+// output formats that can not carry an empty tag.
+func (m *Metric) valuedTags() []*MetricTag {
+	tags := make([]*MetricTag, 0, len(m.Tags))
+	for _, tag := range m.Tags {
+		if tag[0] != "" && tag[1] != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// graphiteTaggedName returns the Graphite 1.1 tagged series name, the tag
+// values are kept as they are apart from the characters Graphite reserves.
+func (m *Metric) graphiteTaggedName(name_prefix, suffix string) string {
+	name := strings.ReplaceAll(m.Name, " ", "_") + suffix
+	if name_prefix != "" {
+		name = name_prefix + "." + name
+	}
+	series := []string{graphiteTagEscaper.Replace(name)}
+	for _, tag := range m.valuedTags() {
+		series = append(series, graphiteTagEscaper.Replace(tag[0])+"="+graphiteTagEscaper.Replace(tag[1]))
+	}
+	return strings.Join(series, ";")
+}
+
+func (m *Metric) GraphiteTagged(name_prefix string) string {
+	return fmt.Sprintf("%s %d %d", m.graphiteTaggedName(name_prefix, ""), m.Value, m.Timestamp.Unix())
 }
 
 func main() {
+	// Run as the mutator for the sensu output format
+	if len(os.Args) > 1 && os.Args[1] == "mutator" {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+		mutator := sensu.NewGoMutator(&mutatorConfig, nil, checkMutatorEvent, mutateEvent)
+		mutator.Execute()
+		return
+	}
+
 	check := sensu.NewGoCheck(&plugin.PluginConfig, options, checkArgs, executeCheck, false)
 	check.Execute()
 }
 
 func checkArgs(event *v2.Event) (int, error) {
-	// Check that we got an appropriate statistics format
-	switch plugin.StatisticsFormat {
-	case "file":
-		if plugin.StatisticsFilePath == "" {
-			return sensu.CheckStateUnknown, fmt.Errorf("no statistics file path specified when using file format")
+	switch plugin.AddressFamily {
+	case "", "any", "ipv4", "ipv6", "prefer-ipv4", "prefer-ipv6":
+	default:
+		return sensu.CheckStateUnknown, fmt.Errorf("invalid address family: %s", plugin.AddressFamily)
+	}
+
+	switch plugin.FileRotation {
+	case "", "none", "truncate", "rotate":
+	default:
+		return sensu.CheckStateUnknown, fmt.Errorf("invalid file rotation: %s", plugin.FileRotation)
+	}
+	switch plugin.UnparsedLines {
+	case "", "ignore", "report", "fail":
+	default:
+		return sensu.CheckStateUnknown, fmt.Errorf("invalid unparsed lines handling: %s", plugin.UnparsedLines)
+	}
+	if plugin.RndcTimeout < 0 {
+		return sensu.CheckStateUnknown, fmt.Errorf("the rndc timeout can not be negative")
+	}
+	// Both report the change of every metric, one would overwrite the other
+	if plugin.DumpDeltas && plugin.StateFilePath != "" {
+		return sensu.CheckStateUnknown, fmt.Errorf("--dump-deltas can not be used together with --state-file")
+	}
+
+	for _, ratio := range healthRatios() {
+		if err := ratio.validate(); err != nil {
+			return sensu.CheckStateUnknown, err
 		}
-		// Check that the file exists
-		if _, err := os.Stat(plugin.StatisticsFilePath); os.IsNotExist(err) {
-			return sensu.CheckStateUnknown, fmt.Errorf("statistics file does not exist: %s", plugin.StatisticsFilePath)
+	}
+
+	for _, section := range plugin.StatisticsSections {
+		if !slices.Contains(statisticsSections, section) {
+			return sensu.CheckStateUnknown, fmt.Errorf("invalid statistics section: %s", section)
 		}
-		// Check that the file is readable
-		if _, err := os.Open(plugin.StatisticsFilePath); err != nil {
-			return sensu.CheckStateUnknown, fmt.Errorf("unable to read statistics file: %s", err)
+	}
+
+	// Check that the TLS and authentication options work
+	if _, err := statsTLSConfig(); err != nil {
+		return sensu.CheckStateUnknown, err
+	}
+	if _, err := statsAuthorization(); err != nil {
+		return sensu.CheckStateUnknown, err
+	}
+
+	if plugin.ConnectTimeout < 0 || plugin.ReadTimeout < 0 || plugin.Timeout < 0 {
+		return sensu.CheckStateUnknown, fmt.Errorf("the statistics channel timeouts can not be negative")
+	}
+	if plugin.Retries < 0 || plugin.RetryBackoff < 0 {
+		return sensu.CheckStateUnknown, fmt.Errorf("the retries and retry backoff can not be negative")
+	}
+
+	// Check that we got appropriate targets
+	plugin.targets = nil
+	for _, spec := range plugin.Targets {
+		t, err := parseTarget(spec)
+		if err != nil {
+			return sensu.CheckStateUnknown, err
 		}
-	case "xml", "json":
-		if plugin.StatisticsIP == "" {
-			return sensu.CheckStateUnknown, fmt.Errorf("no statistics IP specified when using %s format", plugin.StatisticsFormat)
+		// Only the options, a target that can not be read fails on its own
+		if err := t.validate(); err != nil {
+			return sensu.CheckStateUnknown, fmt.Errorf("%s: %s", spec, err)
 		}
-		// Check that the IP is valid
-		// TODO: Maybe allow a hostname here?
-		if net.ParseIP(plugin.StatisticsIP) == nil {
-			return sensu.CheckStateUnknown, fmt.Errorf("invalid statistics IP specified: %s", plugin.StatisticsIP)
+		// The address is the instance tag, which has to tell the targets apart
+		if slices.ContainsFunc(plugin.targets, func(other *target) bool { return other.Name == t.Name }) {
+			return sensu.CheckStateUnknown, fmt.Errorf("duplicate target instance: %s", t.Name)
 		}
-
-		// Check that the port is valid
-		if plugin.StatisticsPort < 1 || plugin.StatisticsPort > 65535 {
-			return sensu.CheckStateUnknown, fmt.Errorf("invalid statistics port specified: %d", plugin.StatisticsPort)
+		plugin.targets = append(plugin.targets, t)
+	}
+	if len(plugin.targets) == 0 {
+		// A single target has no others to hide, so it is checked up front
+		t := plugin.defaultTarget()
+		if err := t.validate(); err != nil {
+			return sensu.CheckStateUnknown, err
 		}
-	default:
-		return sensu.CheckStateUnknown, fmt.Errorf("invalid statistics format: %s", plugin.StatisticsFormat)
+		if err := t.check(); err != nil {
+			return sensu.CheckStateUnknown, err
+		}
+		if t.Host != "" {
+			if err := checkResolves(t.Host); err != nil {
+				return sensu.CheckStateUnknown, err
+			}
+		}
+	} else if plugin.Concurrency < 1 {
+		return sensu.CheckStateUnknown, fmt.Errorf("the concurrency must be at least 1")
+	}
+
+	if plugin.ScrapeInterval < 0 || plugin.ScrapeTimeout < 0 {
+		return sensu.CheckStateUnknown, fmt.Errorf("the scrape interval and timeout can not be negative")
 	}
 
 	return sensu.CheckStateOK, nil
 }
 
 func executeCheck(event *v2.Event) (int, error) {
-	switch plugin.StatisticsFormat {
-	case "file":
-		if err := readStatisticsFile(); err != nil {
-			return sensu.CheckStateCritical, fmt.Errorf("error reading statistics file: %s", err)
+	plugin.channelTimeout = seconds(plugin.Timeout)
+
+	// Serve the metrics to Prometheus until stopped
+	if plugin.ListenAddress != "" {
+		if err := runExporter(); err != nil {
+			return sensu.CheckStateCritical, fmt.Errorf("error running exporter: %s", err)
+		}
+		return sensu.CheckStateOK, nil
+	}
+
+	if err := readStatistics(); err != nil {
+		return readErrorState(err), err
+	}
+
+	// Work out the changes since the previous check run
+	if plugin.StateFilePath != "" {
+		if err := applyState(plugin.StateFilePath, plugin.returnMetrics, plugin.bootTime, plugin.instanceBootTimes); err != nil {
+			return sensu.CheckStateUnknown, fmt.Errorf("error updating state file: %s", err)
 		}
-	case "xml", "json":
-		if err := readStatisticsChannel(); err != nil {
-			return sensu.CheckStateCritical, fmt.Errorf("error reading statistics channel: %s", err)
+	}
+
+	// Compare the derived ratios against the thresholds
+	checkState, summary := checkHealthRatios(plugin.returnMetrics)
+	summaries := []string{}
+	if summary != "" {
+		summaries = append(summaries, summary)
+	}
+	// Lines of the statistics file the parser missed hide format changes
+	if unparsedState, summary := unparsedSummary(plugin.unparsedLines); summary != "" {
+		checkState = max(checkState, unparsedState)
+		summaries = append(summaries, summary)
+	}
+	// Statistics that stopped being updated keep reporting the same numbers
+	if staleState, summary := checkStaleness(plugin.dumpTime, time.Now()); summary != "" {
+		checkState = max(checkState, staleState)
+		summaries = append(summaries, summary)
+	}
+	// A target that could not be read fails the check, the others still report
+	for _, err := range plugin.targetErrors {
+		errorState := readErrorState(err)
+		checkState = max(checkState, errorState)
+		summaries = append(summaries, fmt.Sprintf("%s: %s", stateNames[errorState], err))
+	}
+	for _, summary := range summaries {
+		switch plugin.OutputFormat {
+		case "prometheus", "influxdb":
+			// Keep the output parsable, both formats have comment lines
+			fmt.Println("# " + summary)
+		case "graphite", "graphite-tagged", "openmetrics":
+			// These formats have no free form comments, keep the summary out
+			// of the metrics
+			fmt.Fprintln(os.Stderr, summary)
+		default:
+			fmt.Println(summary)
 		}
 	}
 
 	// Dump out the metrics loaded from the statistics file or channel
 	switch plugin.OutputFormat {
 	case "graphite":
-		OutputMetricsGraphite()
+		OutputMetricsGraphite(graphitePrefix())
+	case "graphite-tagged":
+		OutputMetricsGraphiteTagged(graphitePrefix())
+	case "influxdb":
+		OutputMetricsInfluxDB()
 	case "prometheus":
 		OutputMetricsPrometheus()
+	case "openmetrics":
+		OutputMetricsOpenMetrics()
+	case "sensu":
+		if err := OutputMetricsSensu(); err != nil {
+			return sensu.CheckStateUnknown, fmt.Errorf("error writing sensu metrics: %s", err)
+		}
 	}
 
-	return sensu.CheckStateOK, nil
+	return checkState, nil
 }
 
 // Read from statistics file
 func readStatisticsFile() error {
-	dnsStats, err := os.ReadFile(plugin.StatisticsFilePath)
+	stats, err := parseStatisticsFile(plugin.StatisticsFilePath)
 	if err != nil {
 		return err
 	}
+	plugin.setStatistics(stats)
+	return nil
+}
 
-	// Parse the statistics file
-	var statsReadTime time.Time
+func parseStatisticsFile(path string) (*statistics, error) {
+	var newest, previous *statisticsDump
+	if plugin.RndcCommand != "" {
+		// Have named write a new dump and read that one
+		request := plugin.dumpRequest
+		if request == nil {
+			request = requestStatisticsDump([]string{path})
+		}
+		var err error
+		newest, previous, err = request.wait(path)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		dnsStats, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		// rndc stats appends a dump every time, only the newest complete one counts
+		newest, previous = newestStatisticsDumps(splitStatisticsDumps(string(dnsStats)))
+		if newest == nil {
+			return nil, fmt.Errorf("no complete statistics dump in %s", path)
+		}
+	}
+	// Without a dump header the file was last written when the dump was
+	if newest.Time.IsZero() {
+		statsInfo, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		newest.Time = statsInfo.ModTime()
+	}
+	stats := &statistics{DumpTime: newest.Time}
+	stats.Metrics, stats.Unparsed = parseStatisticsDump(path, newest)
+
+	// Work out the changes since the dump before it
+	if plugin.DumpDeltas && previous != nil {
+		previousMetrics, _ := parseStatisticsDump(path, previous)
+		setChanges(stats.Metrics, stateMetrics(previousMetrics), nil, nil)
+	}
+
+	return stats, nil
+}
+
+// parseStatisticsDump reads the metrics of one dump of the statistics file,
+// and the lines it did not recognize
+func parseStatisticsDump(path string, dump *statisticsDump) ([]*Metric, []*unparsedLine) {
+	metrics := make([]*Metric, 0)
+	unparsed := make([]*unparsedLine, 0)
 
 	namedStats := &namedStats{}
 	namedStats.statsTags = []*MetricTag{}
 
+	// Sections are tagged the same way the JSON and XML readers tag their counter groups
+	sectionTags := map[string]*MetricTag{
+		"Incoming Requests":              {"server", "opcodes"},
+		"Incoming Queries":               {"server", "qtypes"},
+		"Outgoing Rcodes":                {"server", "rcodes"},
+		"Name Server Statistics":         {"server", "nsstat"},
+		"Zone Maintenance Statistics":    {"server", "zonestats"},
+		"Socket I/O Statistics":          {"server", "sockstats"},
+		"Outgoing Queries":               {"type", "resqtype"},
+		"Resolver Statistics":            {"type", "resstats"},
+		"Cache Statistics":               {"type", "cachestats"},
+		"Cache DB RRsets":                {"type", "cache"},
+		"ADB stats":                      {"type", "adbstat"},
+		"Per Zone Query Statistics":      {"type", "rcode"},
+		"Per Zone Glue Cache Statistics": {"type", "gluecache"},
+	}
+
 	// Regular expressions for parsing the statistics file
 	var statsFile = make(map[string]*regexp.Regexp)
-	statsFile["start_end"], _ = regexp.Compile(`^(?:[-+]{3}) Statistics Dump (?:[-+]{3}) \((?P<unixtime>[0-9]*)\)$`)
 	statsFile["sections"], _ = regexp.Compile(`^(?:[+]{2}) (?P<section>[a-zA-Z0-9_/ ]+) (?:[+]{2})$`)
+	statsFile["any_section"], _ = regexp.Compile(`^(?:[+]{2}) (?P<section>.*) (?:[+]{2})$`)
 	statsFile["metric"], _ = regexp.Compile(`^\s*(?P<value>[0-9]+) (?P<name>[-a-zA-Z0-9_/!#()<> ]+)\s*$`)
 	statsFile["view"], _ = regexp.Compile(`^\[View: (?P<view>[a-zA-Z0-9_/ ]+)\]$`)
 	statsFile["view_cache"], _ = regexp.Compile(`^\[View: (?P<view>[a-zA-Z0-9_/ ]+) \(Cache: (?P<cache>[a-zA-Z0-9_/ ]+)\)\]$`)
@@ -209,264 +958,261 @@ func readStatisticsFile() error {
 	statsFile["zone"], _ = regexp.Compile(`^\[(?P<zone>\.|(?:[-0-9a-zA-Z]+\.)(?:[-0-9a-zA-Z]+){1,}|(?:[0-9A-F]+\.)*(?:IN-ADDR|IP6|HOME|EMPTY\.AS112)\.ARPA)\]$`)
 	statsFile["bind_var"], _ = regexp.Compile(`^\[(?P<bind_var>[a-z.]+) \(view: _bind\)\]$`)
 
-	for _, line := range strings.Split(string(dnsStats), "\n") {
+	addUnparsed := func(idx int, line string) {
+		unparsed = append(unparsed, &unparsedLine{
+			Path:    path,
+			Number:  dump.FirstLine + idx,
+			Context: namedStats.context(),
+			Line:    line,
+		})
+	}
+
+	for idx, line := range dump.Lines {
 		// Parse the line
-		if matched := statsFile["start_end"].FindStringSubmatch(line); matched != nil {
-			// Start of a new statistics file or end of the file
-			if statsReadTime.Equal(time.Time{}) {
-				unixTime, _ := strconv.ParseInt(matched[1], 10, 64)
-				statsReadTime = time.Unix(unixTime, 0)
-			}
-		} else if section := statsFile["sections"].FindStringSubmatch(line); section != nil {
+		if section := statsFile["sections"].FindStringSubmatch(line); section != nil {
 			// Start of a new section
 			namedStats.curLevel = section[1]
-			namedStats.statsTags = []*MetricTag{}
-		} else if metric := statsFile["metric"].FindStringSubmatch(line); metric != nil {
+			namedStats.sectionTag = sectionTags[section[1]]
+			namedStats.unknownSection = false
+			namedStats.setTags()
+		} else if section := statsFile["any_section"].FindStringSubmatch(line); section != nil {
+			// Start of a section the parser does not recognize, its counters
+			// are reported as unparsed rather than under the previous section
+			namedStats.curLevel = section[1]
+			namedStats.sectionTag = nil
+			namedStats.unknownSection = true
+			namedStats.setTags()
+			addUnparsed(idx, line)
+		} else if metric := statsFile["metric"].FindStringSubmatch(line); metric != nil && !namedStats.unknownSection {
 			// Metric
 			value, _ := strconv.ParseInt(metric[1], 10, 64)
-			plugin.returnMetrics = append(plugin.returnMetrics, &Metric{
+			metrics = append(metrics, &Metric{
 				Name:      metric[2],
 				Value:     value,
-				Timestamp: statsReadTime,
+				Timestamp: dump.Time,
 				Tags:      namedStats.statsTags,
 			})
 		} else if view := statsFile["view"].FindStringSubmatch(line); view != nil {
-			namedStats.statsTags = append(namedStats.statsTags, &MetricTag{"view", view[1]})
+			namedStats.setTags(&MetricTag{"view", viewName(view[1])})
 		} else if viewCache := statsFile["view_cache"].FindStringSubmatch(line); viewCache != nil {
-			namedStats.statsTags = append(namedStats.statsTags, &MetricTag{"view", viewCache[1]})
-			namedStats.statsTags = append(namedStats.statsTags, &MetricTag{"cache", viewCache[2]})
+			namedStats.setTags(&MetricTag{"view", viewName(viewCache[1])}, &MetricTag{"cache", viewCache[2]})
 		} else if subsection := statsFile["subsection"].FindStringSubmatch(line); subsection != nil {
-			namedStats.statsTags = append(namedStats.statsTags, &MetricTag{"subsection", subsection[1]})
+			namedStats.setTags(&MetricTag{"subsection", subsection[1]})
 		} else if zone := statsFile["zone"].FindStringSubmatch(line); zone != nil {
-			namedStats.statsTags = append(namedStats.statsTags, &MetricTag{"zone", zone[1]})
+			namedStats.setTags(&MetricTag{"zone", zone[1]})
 		} else if bindVar := statsFile["bind_var"].FindStringSubmatch(line); bindVar != nil {
-			namedStats.statsTags = append(namedStats.statsTags, &MetricTag{"bind_var", bindVar[1]})
-			namedStats.statsTags = append(namedStats.statsTags, &MetricTag{"view", "_bind"})
+			namedStats.setTags(&MetricTag{"view", "_bind"}, &MetricTag{"zone", bindVar[1]})
 		} else if strings.Trim(line, " ") == "" {
 			// Skip blank lines
 			continue
 		} else {
-			// Unrecognized line
-			// XXX: Handle this later
-			continue
+			// Unrecognized line, kept for reporting
+			addUnparsed(idx, line)
 		}
 	}
 
-	return nil
+	return metrics, unparsed
 }
 
 // Read from statistics channel
 func readStatisticsChannel() error {
-	// Make the URL for connecting to the statistics channel
-	tcpAddr := net.TCPAddr{
-		IP:   net.ParseIP(plugin.StatisticsIP),
-		Port: plugin.StatisticsPort,
+	stats, err := fetchStatistics(plugin.defaultTarget())
+	if err != nil {
+		return err
 	}
+	plugin.setStatistics(stats)
+	return nil
+}
 
-	statsUrl := url.URL{
-		Scheme: "http",
-		Host:   tcpAddr.String(),
-		Path:   "/",
-	}
-	if plugin.StatisticsFormat == "xml" {
-		statsUrl.Path = "/xml/v3"
+func fetchStatistics(t *target) (*statistics, error) {
+	// Give up on the statistics channel when the overall timeout runs out
+	ctx := context.Background()
+	if plugin.channelTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, plugin.channelTimeout)
+		defer cancel()
 	}
-	if plugin.StatisticsFormat == "json" {
-		statsUrl.Path = "/json/v1"
+
+	// Resolve the statistics channel host, in the order of the address family
+	// preference, unless the channel is on a Unix socket
+	host := t.Host
+	port := strconv.Itoa(t.Port)
+	var dial func(ctx context.Context, network, addr string) (net.Conn, error)
+	if t.Socket != "" {
+		host, port = "localhost", "80"
+		dial = dialSocket(t.Socket, seconds(plugin.ConnectTimeout), seconds(plugin.ReadTimeout))
+	} else {
+		addresses, err := resolveHost(ctx, t.Host, plugin.AddressFamily)
+		if err != nil {
+			return nil, err
+		}
+		dial = dialAddresses(addresses, port, seconds(plugin.ConnectTimeout), seconds(plugin.ReadTimeout))
 	}
 
-	statsReq, _ := http.NewRequest("GET", statsUrl.String(), nil)
-	statsReq.Header.Add("Accept", "application/"+plugin.StatisticsFormat)
+	// Make the URLs for connecting to the statistics channel, one for
+	// everything or one per section
+	basePath := t.BasePath + "/"
+	if t.Format == "xml" {
+		basePath = t.BasePath + "/xml/v3"
+	}
+	if t.Format == "json" {
+		basePath = t.BasePath + "/json/v1"
+	}
+	paths := []string{basePath}
+	if len(plugin.StatisticsSections) > 0 {
+		paths = paths[:0]
+		for _, section := range plugin.StatisticsSections {
+			paths = append(paths, basePath+"/"+section)
+		}
+	}
 
-	// Connect to the statistics channel
-	statsClient := &http.Client{}
-	statsResp, err := statsClient.Do(statsReq)
+	authorization, err := statsAuthorization()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	defer func() { _ = statsResp.Body.Close() }()
+	tlsConfig, err := statsTLSConfig()
+	if err != nil {
+		return nil, err
+	}
 
-	if statsResp.StatusCode != 200 {
-		return fmt.Errorf("error reading statistics channel: %s", statsResp.Status)
+	// Connect to the statistics channel
+	statsClient := &http.Client{
+		Transport: &http.Transport{
+			DialContext:     dial,
+			TLSClientConfig: tlsConfig,
+		},
 	}
+	defer statsClient.CloseIdleConnections()
 
-	// Read the XML statistics
-	var statsData []byte
-	readData := make([]byte, 1024)
-	for {
-		n, err := statsResp.Body.Read(readData)
+	results := make([]*statistics, len(paths))
+	errs := make([]error, len(paths))
+	fetch := func(idx int) {
+		statsUrl := url.URL{
+			Scheme: t.Scheme,
+			Host:   net.JoinHostPort(host, port),
+			Path:   paths[idx],
+		}
+		statsReq, _ := http.NewRequest("GET", statsUrl.String(), nil)
+		statsReq.Header.Add("Accept", "application/"+t.Format)
+		if authorization != "" {
+			statsReq.Header.Set("Authorization", authorization)
+		}
 
+		statsData, err := fetchWithRetries(ctx, func(ctx context.Context) ([]byte, error) {
+			return fetchChannel(ctx, statsClient, statsReq)
+		})
 		if err != nil {
-			if err == io.EOF {
-				if n > 0 {
-					statsData = append(statsData, readData[:n]...)
-				}
-				break
-			}
-			return err
+			errs[idx] = err
+			return
 		}
-		if n > 0 {
-			statsData = append(statsData, readData[:n]...)
-		} else {
-			break
+
+		// Read the statistics from the channel
+		if t.Format == "xml" {
+			// Read the XML statistics
+			results[idx], errs[idx] = parseXmlStats(statsData)
+			return
 		}
+		// Read the JSON statistics
+		results[idx], errs[idx] = parseJsonStats(statsData)
 	}
 
-	statsClient.CloseIdleConnections()
-
-	_ = statsResp.Body.Close()
-
-	// Read the statistics from the channel
-	switch plugin.StatisticsFormat {
-	case "xml":
-		// Read the XML statistics
-		if err := ReadXmlStats(statsData); err != nil {
-			return err
+	if plugin.ParallelSections {
+		var wg sync.WaitGroup
+		for idx := range paths {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				fetch(idx)
+			}()
 		}
-	case "json":
-		// Read the JSON statistics
-		if err := ReadJsonStats(statsData); err != nil {
-			return err
+		wg.Wait()
+	} else {
+		for idx := range paths {
+			fetch(idx)
+			if errs[idx] != nil {
+				break
+			}
 		}
 	}
 
-	return nil
+	for idx, err := range errs {
+		if err != nil {
+			if len(plugin.StatisticsSections) > 0 {
+				return nil, fmt.Errorf("%s section: %w", plugin.StatisticsSections[idx], err)
+			}
+			return nil, err
+		}
+	}
+	return mergeStatistics(results), nil
 }
 
-func OutputMetricsGraphite() {
+func OutputMetricsGraphite(prefix string) {
 	// Output metrics in Graphite format
 	for _, metric := range plugin.returnMetrics {
-		fmt.Println(metric.Graphite("bind.dns"))
-	}
-}
-
-type PromLabel struct {
-	Name  string
-	Value string
-}
-
-type PrometheusMetric struct {
-	Name      string
-	Label     []*PromLabel
-	Value     int64
-	Timestamp time.Time
-}
-
-type PrometheusMetricGroup struct {
-	Name    string
-	Metrics []*PrometheusMetric
-}
-
-type PrometheusMetricGroups struct {
-	Groups []*PrometheusMetricGroup
-}
-
-func (pmg *PrometheusMetricGroups) findOrAdd(pm *PrometheusMetric) int {
-	idx := -1
-	for i, group := range pmg.Groups {
-		if group.Name == pm.Name {
-			idx = i
-			break
+		fmt.Println(metric.Graphite(prefix))
+		if metric.Change != nil {
+			metric_path := metric.graphitePath(prefix)
+			fmt.Printf("%s_delta %d %d\n", metric_path, metric.Change.Delta, metric.Timestamp.Unix())
+			fmt.Printf("%s_rate %s %d\n", metric_path, strconv.FormatFloat(metric.Change.Rate, 'f', -1, 64), metric.Timestamp.Unix())
 		}
 	}
-	if idx == -1 {
-		group := &PrometheusMetricGroup{Name: pm.Name}
-		pmg.Groups = append(pmg.Groups, group)
-		idx = len(pmg.Groups) - 1
-	}
-	return idx
 }
 
-func promLabelsToString(labels []*PromLabel) string {
-	var label_strings []string
-	for _, label := range labels {
-		label_strings = append(label_strings, fmt.Sprintf("%s=\"%s\"", label.Name, label.Value))
-	}
-	return strings.Join(label_strings, ",")
-}
-
-func OutputMetricsPrometheus() {
-	// Gather all the metrics for sorting
-	prom_metric_groups := &PrometheusMetricGroups{Groups: make([]*PrometheusMetricGroup, 0)}
-
+// metricPoints converts the metrics into Sensu metric points, keeping the
+// original tag names and values.
+func metricPoints() []*v2.MetricPoint {
+	points := make([]*v2.MetricPoint, 0, len(plugin.returnMetrics))
 	for _, metric := range plugin.returnMetrics {
-		parsed_metrics := 0
-		prom_metrics := make([]*MetricTag, 0)
-		prom_metrics = append(prom_metrics, &MetricTag{"type", "qtype"})
-		prom_metrics = append(prom_metrics, &MetricTag{"type", "rcode"})
-		prom_metrics = append(prom_metrics, &MetricTag{"type", "nsstat"})
-		prom_metrics = append(prom_metrics, &MetricTag{"type", "zonestat"})
-		prom_metrics = append(prom_metrics, &MetricTag{"type", "resstats"})
-		prom_metrics = append(prom_metrics, &MetricTag{"type", "cache"})
-		prom_metrics = append(prom_metrics, &MetricTag{"type", "adbstat"})
-		prom_metrics = append(prom_metrics, &MetricTag{"type", "gluecache"})
-		prom_metrics = append(prom_metrics, &MetricTag{"type", "cachestats"})
-		prom_metrics = append(prom_metrics, &MetricTag{"server", "qtype"})
-		prom_metrics = append(prom_metrics, &MetricTag{"server", "opcodes"})
-
-		for _, prom_metric := range prom_metrics {
-			if prom_metric_groups.makePromMetric(metric, prom_metric) {
-				parsed_metrics++
-			}
+		point_tags := make([]*v2.MetricTag, 0, len(metric.Tags))
+		for _, tag := range metric.Tags {
+			point_tags = append(point_tags, &v2.MetricTag{Name: tag[0], Value: tag[1]})
 		}
-
-		if contains(metric.Tags, "type", "response-size") || contains(metric.Tags, "type", "request-size") {
-			prom_labels := make([]*PromLabel, 0)
-			prom_labels = append(prom_labels, &PromLabel{Name: "packet_size", Value: metric.Name})
-			prom_name := make([]string, 0)
-			for _, tag := range metric.Tags {
-				tag_value := strings.ReplaceAll(tag[1], "-", "_")
-				prom_name = append(prom_name, tag_value)
-			}
-			prom_metric := &PrometheusMetric{
-				Name:      strings.Join(prom_name, "_"),
-				Label:     prom_labels,
-				Value:     metric.Value,
-				Timestamp: metric.Timestamp,
-			}
-			pmg_idx := prom_metric_groups.findOrAdd(prom_metric)
-			prom_metric_groups.Groups[pmg_idx].Metrics = append(prom_metric_groups.Groups[pmg_idx].Metrics, prom_metric)
+		points = append(points, &v2.MetricPoint{
+			Name:      metric.Name,
+			Value:     float64(metric.Value),
+			Timestamp: metric.Timestamp.UnixNano(),
+			Tags:      point_tags,
+		})
+		if metric.Change != nil {
+			points = append(points, &v2.MetricPoint{
+				Name:      metric.Name + "_delta",
+				Value:     float64(metric.Change.Delta),
+				Timestamp: metric.Timestamp.UnixNano(),
+				Tags:      point_tags,
+			})
+			points = append(points, &v2.MetricPoint{
+				Name:      metric.Name + "_rate",
+				Value:     metric.Change.Rate,
+				Timestamp: metric.Timestamp.UnixNano(),
+				Tags:      point_tags,
+			})
 		}
 	}
+	return points
+}
 
-	// Output metrics in Prometheus format
-	for _, group := range prom_metric_groups.Groups {
-		fmt.Println("# HELP " + group.Name + "_total Bind DNS statistics")
-		fmt.Println("# TYPE " + group.Name + "_total counter")
-
-		for _, metric := range group.Metrics {
-			fmt.Printf("%s_total{%s} %d %d\n", group.Name, promLabelsToString(metric.Label), metric.Value, metric.Timestamp.UnixMilli())
-		}
+// OutputMetricsSensu writes the metrics out as Sensu metric points in a JSON
+// document, for the mutator to add to the event.
+func OutputMetricsSensu() error {
+	metrics := &v2.Metrics{Points: metricPoints()}
+	metricsJson, err := json.Marshal(metrics)
+	if err != nil {
+		return err
 	}
+	fmt.Println(string(metricsJson))
+	return nil
 }
 
-func (pmg *PrometheusMetricGroups) makePromMetric(metric *Metric, metric_tag *MetricTag) bool {
-	if !contains(metric.Tags, metric_tag[0], metric_tag[1]) {
-		return false
-	}
-	prom_labels := make([]*PromLabel, 0)
-	prom_labels = append(prom_labels, &PromLabel{Name: metric_tag[0], Value: metric.Name})
-	prom_name := make([]string, 0)
-	for _, tag := range metric.Tags {
-		tag_value := strings.ReplaceAll(tag[1], "-", "_")
-		if tag[0] == "view" {
-			if tag_value[0:1] == "_" {
-				tag_value = tag_value[1:]
-			}
+func OutputMetricsGraphiteTagged(prefix string) {
+	// Output metrics as Graphite tagged series
+	for _, metric := range plugin.returnMetrics {
+		fmt.Println(metric.GraphiteTagged(prefix))
+		if metric.Change != nil {
+			fmt.Printf("%s %d %d\n", metric.graphiteTaggedName(prefix, "_delta"), metric.Change.Delta, metric.Timestamp.Unix())
+			fmt.Printf("%s %s %d\n", metric.graphiteTaggedName(prefix, "_rate"), strconv.FormatFloat(metric.Change.Rate, 'f', -1, 64), metric.Timestamp.Unix())
 		}
-		prom_name = append(prom_name, tag_value)
 	}
-	prom_metric := &PrometheusMetric{
-		Name:      strings.Join(prom_name, "_"),
-		Label:     prom_labels,
-		Value:     metric.Value,
-		Timestamp: metric.Timestamp,
-	}
-
-	pmg_idx := pmg.findOrAdd(prom_metric)
-	pmg.Groups[pmg_idx].Metrics = append(pmg.Groups[pmg_idx].Metrics, prom_metric)
-	return true
 }
 
 func contains(tags []*MetricTag, tag_name, tag_value string) bool {
@@ -1,6 +1,20 @@
 package main
 
 import (
+	"cmp"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
 	"net"
 	"net/http"
 	"net/http/httptest"
@@ -8,9 +22,15 @@ import (
 	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+	v2 "github.com/sensu/core/v2"
+	"github.com/sensu/sensu-plugin-sdk/sensu"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -115,6 +135,18 @@ func TestCheckArgs(t *testing.T) {
 	ok, err = checkArgs(nil)
 	assert.Equal(ok, 0)
 	assert.NoError(err)
+
+	// The dump deltas and the state file both set the changes, only one can
+	plugin.DumpDeltas = true
+	plugin.StateFilePath = "state.json"
+	ok, err = checkArgs(nil)
+	assert.Equal(ok, 3)
+	assert.Error(err)
+	plugin.StateFilePath = ""
+	ok, err = checkArgs(nil)
+	assert.Equal(ok, 0)
+	assert.NoError(err)
+	plugin.DumpDeltas = false
 }
 
 func TestExecuteCheck(t *testing.T) {
@@ -155,10 +187,18 @@ func TestExecuteCheck(t *testing.T) {
 	}{
 		{"xml", "", namedXmlStats},
 		{"xml", "graphite", namedXmlStats},
+		{"xml", "graphite-tagged", namedXmlStats},
+		{"xml", "influxdb", namedXmlStats},
+		{"xml", "openmetrics", namedXmlStats},
 		{"xml", "prometheus", namedXmlStats},
+		{"xml", "sensu", namedXmlStats},
 		{"json", "", namedJsonStats},
 		{"json", "graphite", namedJsonStats},
+		{"json", "graphite-tagged", namedJsonStats},
+		{"json", "influxdb", namedJsonStats},
+		{"json", "openmetrics", namedJsonStats},
 		{"json", "prometheus", namedJsonStats},
+		{"json", "sensu", namedJsonStats},
 	}
 
 	for _, tc := range tt {
@@ -270,3 +310,1651 @@ func startTestServer(runningServer *testServer) *httptest.Server {
 
 	return dns_stats
 }
+
+func TestReadXmlStatsServerCounters(t *testing.T) {
+	assert := assert.New(t)
+
+	namedXmlStats, err := os.ReadFile("tests/named.xml")
+	assert.NoError(err)
+	assert.NoError(ReadXmlStats(namedXmlStats))
+
+	// The server counters should be tagged the same way as the JSON reader tags them
+	found := map[string]int64{}
+	for _, metric := range plugin.returnMetrics {
+		if len(metric.Tags) == 1 && metric.Tags[0][0] == "server" {
+			found[metric.Tags[0][1]+"."+metric.Name] = metric.Value
+		}
+	}
+	assert.Equal(int64(57145), found["opcodes.QUERY"])
+	assert.Equal(int64(1552), found["rcodes.NXDOMAIN"])
+	assert.Equal(int64(25732), found["qtypes.A"])
+	assert.Equal(int64(53471), found["nsstat.Requestv4"])
+	assert.Contains(found, "zonestats.XfrSuccess")
+	assert.Contains(found, "sockstats.UDP4Open")
+
+	// Zero valued counters are skipped
+	assert.NotContains(found, "opcodes.IQUERY")
+}
+
+func TestReadJsonStatsViews(t *testing.T) {
+	assert := assert.New(t)
+
+	jsonStats := []byte(`{
+		"current-time": "2024-02-09T07:47:46Z",
+		"views": {
+			"internal": {"resolver": {"stats": {"Queryv6": 3}}},
+			"external": {"resolver": {"stats": {"Queryv6": 5}}}
+		}
+	}`)
+	assert.NoError(ReadJsonStats(jsonStats))
+
+	views := map[string]int64{}
+	for _, metric := range plugin.returnMetrics {
+		if metric.Name == "Queryv6" && metric.Tags[0][0] == "view" {
+			views[metric.Tags[0][1]] = metric.Value
+		}
+	}
+	assert.Equal(map[string]int64{"internal": 3, "external": 5}, views)
+
+	// Empty views and zones are skipped
+	assert.NoError(ReadJsonStats([]byte(`{"views": {"x": null, "y": {"zones": [null]}}}`)))
+}
+
+func TestReadJsonStatsCounters(t *testing.T) {
+	assert := assert.New(t)
+
+	// Counters the plugin has never heard of are still collected
+	jsonStats := []byte(`{
+		"current-time": "2024-02-09T07:47:46Z",
+		"qtypes": {"A": 10, "CAA": 2},
+		"nsstats": {"Requestv4": 20, "XfrRej": 3},
+		"sockstats": {"UDP4Open": 4},
+		"views": {
+			"_default": {"resolver": {"stats": {"QryRTT10": 7}}}
+		}
+	}`)
+	assert.NoError(ReadJsonStats(jsonStats))
+
+	found := map[string]int64{}
+	for _, metric := range plugin.returnMetrics {
+		found[metric.Tags[len(metric.Tags)-1][1]+"."+metric.Name] = metric.Value
+	}
+	assert.Equal(int64(2), found["qtypes.CAA"])
+	assert.Equal(int64(3), found["nsstat.XfrRej"])
+	assert.Equal(int64(4), found["sockstats.UDP4Open"])
+	assert.Equal(int64(7), found["resstats.QryRTT10"])
+
+	// Counters above 2^53 keep every digit
+	assert.NoError(ReadJsonStats([]byte(`{"nsstats": {"Requestv4": 9007199254740993}}`)))
+	assert.Equal(int64(9007199254740993), plugin.returnMetrics[0].Value)
+
+	// Traffic histograms the plugin does not know about are skipped
+	jsonStats = []byte(`{
+		"traffic": {
+			"dns-quic-requests-sizes-received-ipv4": {"0-15": 1},
+			"dns-udp-requests-sizes-received-ipv4": {"0-15": 2}
+		}
+	}`)
+	assert.NoError(ReadJsonStats(jsonStats))
+	traffic := map[string]int64{}
+	for _, metric := range plugin.returnMetrics {
+		if metric.Name == "0-15" {
+			traffic[metric.Tags[1][1]] = metric.Value
+		}
+	}
+	assert.Equal(map[string]int64{"udp": 2}, traffic)
+}
+
+func TestHealthThresholds(t *testing.T) {
+	assert := assert.New(t)
+
+	defer func() {
+		plugin.ServfailWarning = 0
+		plugin.ServfailCritical = 0
+		plugin.RecursionRejectedCritical = 0
+	}()
+
+	plugin.StatisticsFormat = "file"
+	plugin.StatisticsFilePath = "tests/named.stats"
+	plugin.OutputFormat = ""
+	plugin.returnMetrics = nil
+
+	// No thresholds configured
+	ok, err := executeCheck(nil)
+	assert.Equal(0, ok)
+	assert.NoError(err)
+
+	// 1225 SERVFAIL out of 24455 responses is just over 5%
+	plugin.ServfailWarning = 5
+	plugin.ServfailCritical = 10
+	ok, summary := checkHealthRatios(plugin.returnMetrics)
+	assert.Equal(1, ok)
+	assert.Equal("WARNING: SERVFAIL ratio 5.01% >= 5.00%", summary)
+
+	// 297 rejected recursive queries out of 24715 requests is about 1.2%
+	plugin.RecursionRejectedCritical = 1
+	ok, summary = checkHealthRatios(plugin.returnMetrics)
+	assert.Equal(2, ok)
+	assert.Contains(summary, "recursion rejected ratio 1.20% >= 1.00%")
+
+	plugin.ServfailWarning = 6
+	plugin.RecursionRejectedCritical = 2
+	ok, summary = checkHealthRatios(plugin.returnMetrics)
+	assert.Equal(0, ok)
+	assert.Equal("OK: SERVFAIL ratio 5.01%, recursion rejected ratio 1.20%", summary)
+
+	// The change since the previous run is used when there is one
+	server := &MetricTag{"server", "rcodes"}
+	metrics := []*Metric{
+		{Name: "SERVFAIL", Value: 1000, Tags: []*MetricTag{server}, Change: &MetricChange{Delta: 15}},
+		{Name: "NOERROR", Value: 1000, Tags: []*MetricTag{server}, Change: &MetricChange{Delta: 85}},
+		{Name: "FORMERR", Value: 100, Tags: []*MetricTag{server}},
+	}
+	plugin.RecursionRejectedCritical = 0
+	ok, summary = checkHealthRatios(metrics)
+	assert.Equal(1, ok)
+	assert.Equal("WARNING: SERVFAIL ratio 7.50% >= 6.00%", summary)
+
+	// Thresholds that can never be reached or are the wrong way around
+	for _, thresholds := range [][2]float64{{-1, 0}, {0, 101}, {20, 10}} {
+		plugin.ServfailWarning, plugin.ServfailCritical = thresholds[0], thresholds[1]
+		ok, err = checkArgs(nil)
+		assert.Equal(3, ok)
+		assert.Error(err)
+	}
+	plugin.ServfailWarning, plugin.ServfailCritical = 10, 10
+	ok, err = checkArgs(nil)
+	assert.Equal(0, ok)
+	assert.NoError(err)
+}
+
+// captureOutput returns what the function writes to stdout and stderr
+func captureOutput(t *testing.T, fn func()) (string, string) {
+	t.Helper()
+	stdout, stderr := os.Stdout, os.Stderr
+	defer func() { os.Stdout, os.Stderr = stdout, stderr }()
+
+	read := func(w **os.File) func() string {
+		r, pw, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		*w = pw
+		done := make(chan string)
+		go func() {
+			data, _ := io.ReadAll(r)
+			done <- string(data)
+		}()
+		return func() string {
+			_ = pw.Close()
+			return <-done
+		}
+	}
+	readStdout, readStderr := read(&os.Stdout), read(&os.Stderr)
+	fn()
+	return readStdout(), readStderr()
+}
+
+func TestSummaryOutput(t *testing.T) {
+	assert := assert.New(t)
+
+	defer func() {
+		plugin.ServfailWarning = 0
+		plugin.OutputFormat = ""
+	}()
+
+	plugin.StatisticsFormat = "file"
+	plugin.StatisticsFilePath = "tests/named.stats"
+	plugin.ServfailWarning = 5
+	summary := "WARNING: SERVFAIL ratio 5.01% >= 5.00%"
+
+	tt := []struct {
+		OutputFormat string
+		Stdout       string
+		Stderr       string
+	}{
+		{"", summary + "\n", ""},
+		{"graphite", "", summary + "\n"},
+		{"graphite-tagged", "", summary + "\n"},
+		{"influxdb", "# " + summary + "\n", ""},
+		{"prometheus", "# " + summary + "\n", ""},
+		{"openmetrics", "", summary + "\n"},
+	}
+
+	for _, tc := range tt {
+		plugin.OutputFormat = tc.OutputFormat
+		var ok int
+		var err error
+		stdout, stderr := captureOutput(t, func() { ok, err = executeCheck(nil) })
+		assert.NoError(err)
+		assert.Equal(1, ok)
+		assert.Equal(tc.Stderr, stderr, tc.OutputFormat)
+		if tc.Stdout != "" {
+			assert.True(strings.HasPrefix(stdout, tc.Stdout), tc.OutputFormat)
+		}
+		assert.Equal(strings.Contains(tc.Stdout, summary), strings.Contains(stdout, summary), tc.OutputFormat)
+	}
+}
+
+func TestApplyState(t *testing.T) {
+	assert := assert.New(t)
+
+	statePath := t.TempDir() + "/state.json"
+	bootTime := time.Unix(1000, 0)
+	firstRun := time.Unix(2000, 0)
+	secondRun := firstRun.Add(10 * time.Second)
+	serverTag := &MetricTag{"server", "nsstat"}
+
+	// Nothing to compare against on the first run
+	metrics := []*Metric{
+		{Name: "Requestv4", Value: 100, Timestamp: firstRun, Tags: []*MetricTag{serverTag}},
+		{Name: "Requestv6", Value: 50, Timestamp: firstRun, Tags: []*MetricTag{serverTag}},
+	}
+	assert.NoError(applyState(statePath, metrics, bootTime, nil))
+	assert.Nil(metrics[0].Change)
+
+	// Requestv6 went down so named must have restarted
+	metrics = []*Metric{
+		{Name: "Requestv4", Value: 150, Timestamp: secondRun, Tags: []*MetricTag{serverTag}},
+		{Name: "Requestv6", Value: 5, Timestamp: secondRun, Tags: []*MetricTag{serverTag}},
+	}
+	assert.NoError(applyState(statePath, metrics, bootTime, nil))
+	assert.Equal(&MetricChange{Delta: 50, Rate: 5}, metrics[0].Change)
+	assert.Equal(int64(5), metrics[1].Change.Delta)
+	assert.True(metrics[1].Change.Reset)
+
+	// A new boot time resets every counter
+	restartTime := secondRun.Add(5 * time.Second)
+	metrics = []*Metric{
+		{Name: "Requestv4", Value: 200, Timestamp: restartTime.Add(20 * time.Second), Tags: []*MetricTag{serverTag}},
+	}
+	assert.NoError(applyState(statePath, metrics, restartTime, nil))
+	assert.Equal(&MetricChange{Delta: 200, Rate: 10, Reset: true}, metrics[0].Change)
+
+	// With several targets only the one that restarted starts over
+	instanceBootTimes := map[string]time.Time{"ns1": bootTime, "ns2": bootTime}
+	instanceMetrics := func(value int64, timestamp time.Time) []*Metric {
+		return []*Metric{
+			{Name: "Requestv4", Value: value, Timestamp: timestamp, Tags: []*MetricTag{{"instance", "ns1"}, serverTag}},
+			{Name: "Requestv4", Value: value, Timestamp: timestamp, Tags: []*MetricTag{{"instance", "ns2"}, serverTag}},
+		}
+	}
+	assert.NoError(applyState(statePath, instanceMetrics(100, firstRun), time.Time{}, instanceBootTimes))
+	instanceBootTimes = map[string]time.Time{"ns1": bootTime, "ns2": restartTime}
+	metrics = instanceMetrics(300, firstRun.Add(40*time.Second))
+	assert.NoError(applyState(statePath, metrics, time.Time{}, instanceBootTimes))
+	assert.Equal(&MetricChange{Delta: 200, Rate: 5}, metrics[0].Change)
+	assert.Equal(&MetricChange{Delta: 300, Rate: 12, Reset: true}, metrics[1].Change)
+}
+
+func TestOutputMetricsSensu(t *testing.T) {
+	assert := assert.New(t)
+
+	plugin.returnMetrics = []*Metric{
+		{
+			Name:      "QryRTT10",
+			Value:     7,
+			Timestamp: time.Unix(2000, 0),
+			Tags:      []*MetricTag{{"view", "_default"}, {"type", "resstats"}},
+		},
+	}
+
+	// The mutator moves the points from the check output into the event
+	stdout, _ := captureOutput(t, func() {
+		fmt.Println("WARNING: SERVFAIL ratio 5.01% >= 5.00%")
+		assert.NoError(OutputMetricsSensu())
+	})
+	event := v2.FixtureEvent("entity", "check")
+	event.Check.Output = stdout
+	event.Check.OutputMetricHandlers = []string{"influxdb"}
+	assert.NoError(checkMutatorEvent(event))
+	event, err := mutateEvent(event)
+	assert.NoError(err)
+
+	assert.Equal("WARNING: SERVFAIL ratio 5.01% >= 5.00%\n", event.Check.Output)
+	assert.Equal([]string{"influxdb"}, event.Metrics.Handlers)
+	assert.Equal([]*v2.MetricPoint{
+		{
+			Name:      "QryRTT10",
+			Value:     7,
+			Timestamp: time.Unix(2000, 0).UnixNano(),
+			Tags: []*v2.MetricTag{
+				{Name: "view", Value: "_default"},
+				{Name: "type", Value: "resstats"},
+			},
+		},
+	}, event.Metrics.Points)
+
+	// Output without points is left alone
+	event = v2.FixtureEvent("entity", "check")
+	event.Check.Output = "OK\n"
+	event, err = mutateEvent(event)
+	assert.NoError(err)
+	assert.Nil(event.Metrics)
+	assert.Equal("OK\n", event.Check.Output)
+
+	event.Check.Output = "{not json"
+	_, err = mutateEvent(event)
+	assert.Error(err)
+}
+
+func TestMetricInfluxDB(t *testing.T) {
+	assert := assert.New(t)
+
+	metric := &Metric{
+		Name:      "queries resulted in SERVFAIL",
+		Value:     12,
+		Timestamp: time.Unix(2000, 0),
+		Tags: []*MetricTag{
+			{"view", "split view,a=b"},
+			{"zone", "example.com"},
+			{"zone_type", "master"},
+			{"type", "rcode"},
+			{"class", ""},
+		},
+	}
+	assert.Equal(
+		`queries\ resulted\ in\ SERVFAIL,type=rcode,view=split\ view\,a\=b,zone=example.com,zone_type=master value=12i 2000000000000`,
+		metric.InfluxDB(),
+	)
+
+	metric.Tags = nil
+	metric.Change = &MetricChange{Delta: 3, Rate: 0.5}
+	assert.Equal(`queries\ resulted\ in\ SERVFAIL value=12i,delta=3i,rate=0.5 2000000000000`, metric.InfluxDB())
+}
+
+func TestMetricGraphite(t *testing.T) {
+	assert := assert.New(t)
+
+	metric := &Metric{
+		Name:      "NOERROR",
+		Value:     12,
+		Timestamp: time.Unix(2000, 0),
+		Tags: []*MetricTag{
+			{"view", "_default"},
+			{"zone", "8.B.D.0.1.0.0.2.IP6.ARPA"},
+			{"zone_type", "master"},
+			{"type", "rcode"},
+		},
+	}
+	assert.Equal("bind.dns.view__default.zone_8BD0_1002_IP6_ARPA.zone_type_master.type_rcode.NOERROR 12 2000", metric.Graphite("bind.dns"))
+	assert.Equal("bind.dns.NOERROR;view=_default;zone=8.B.D.0.1.0.0.2.IP6.ARPA;zone_type=master;type=rcode 12 2000", metric.GraphiteTagged("bind.dns"))
+
+	metric.Tags = []*MetricTag{{"local-address", "192.0.2.1#53"}}
+	assert.Equal("bind.dns.local-address_192_0_2_1#53.NOERROR 12 2000", metric.Graphite("bind.dns"))
+	assert.Equal("bind.dns.NOERROR;local-address=192.0.2.1#53 12 2000", metric.GraphiteTagged("bind.dns"))
+}
+
+func TestGraphiteTemplate(t *testing.T) {
+	assert := assert.New(t)
+
+	defer func() {
+		plugin.GraphitePrefix = ""
+		plugin.GraphiteTemplate = nil
+		plugin.EntityName = ""
+	}()
+
+	metric := &Metric{
+		Name:      "NOERROR",
+		Value:     12,
+		Timestamp: time.Unix(2000, 0),
+		Tags:      []*MetricTag{{"type", "rcode"}, {"view", "_default"}, {"class", "IN"}},
+	}
+
+	// The entity name falls back to the host name
+	hostname, _ := os.Hostname()
+	plugin.GraphitePrefix = "bind.{entity}"
+	assert.Equal("bind."+strings.ReplaceAll(hostname, ".", "_"), graphitePrefix())
+	plugin.EntityName = "dns1.example.com"
+	assert.Equal("bind.dns1_example_com", graphitePrefix())
+
+	plugin.GraphiteTemplate = []string{"view", "type"}
+	assert.Equal("bind.view__default.type_rcode.NOERROR 12 2000", metric.Graphite("bind"))
+
+	plugin.GraphiteTemplate = []string{"view", "*"}
+	assert.Equal("bind.view__default.type_rcode.class_IN.NOERROR 12 2000", metric.Graphite("bind"))
+
+	plugin.GraphiteTemplate = []string{"zone"}
+	assert.Equal("NOERROR 12 2000", metric.Graphite(""))
+}
+
+func TestGraphiteReaders(t *testing.T) {
+	assert := assert.New(t)
+
+	defer func() {
+		plugin.GraphiteTemplate = nil
+	}()
+
+	// The template picks the tags by name, so the same counter gets the same
+	// path whichever way it was read
+	plugin.GraphiteTemplate = []string{"view", "server", "type"}
+	expected := []string{"bind.server_opcodes.QUERY", "bind.server_qtypes.A", "bind.server_rcodes.NOERROR", "bind.view__default.type_cache.A"}
+	paths := func() []string {
+		found := []string{}
+		for _, metric := range plugin.returnMetrics {
+			path := strings.Fields(metric.Graphite("bind"))[0]
+			if slices.Contains(expected, path) {
+				found = append(found, path)
+			}
+		}
+		slices.Sort(found)
+		return found
+	}
+
+	plugin.StatisticsFilePath = "tests/named.stats"
+	assert.NoError(readStatisticsFile())
+	assert.Equal(expected, paths())
+
+	xmlStats, err := os.ReadFile("tests/named.xml")
+	assert.NoError(err)
+	assert.NoError(ReadXmlStats(xmlStats))
+	assert.Equal(expected, paths())
+
+	jsonStats, err := os.ReadFile("tests/named.json")
+	assert.NoError(err)
+	assert.NoError(ReadJsonStats(jsonStats))
+	assert.Equal(expected, paths())
+}
+
+func TestMetricIsGauge(t *testing.T) {
+	assert := assert.New(t)
+
+	// Gauges and counters by name and counter group, as each reader tags them
+	plugin.StatisticsFilePath = "tests/named.stats"
+	plugin.returnMetrics = nil
+	assert.NoError(readStatisticsFile())
+	fileMetrics := plugin.returnMetrics
+
+	namedXmlStats, err := os.ReadFile("tests/named.xml")
+	assert.NoError(err)
+	assert.NoError(ReadXmlStats(namedXmlStats))
+	xmlMetrics := plugin.returnMetrics
+
+	gauges := map[string]bool{}
+	for _, metric := range append(fileMetrics, xmlMetrics...) {
+		gauges[metric.group()+"."+metric.Name] = metric.isGauge()
+	}
+
+	assert.True(gauges["cachestats.CacheNodes"])
+	assert.False(gauges["cachestats.CacheHits"])
+	assert.True(gauges["cachestats.cache database nodes"])
+	assert.False(gauges["cachestats.cache hits"])
+	assert.True(gauges["nsstat.TCPConnHighWater"])
+	assert.True(gauges["nsstat.TCP connection high-water"])
+	assert.False(gauges["nsstat.Requestv4"])
+	assert.True(gauges["sockstats.UDP4Active"])
+	assert.True(gauges["sockstats.UDP/IPv4 sockets active"])
+	assert.True(gauges["memory.InUse"])
+	assert.False(gauges["memory.TotalUse"])
+	assert.True(gauges["context.Hiwater"])
+	assert.True(gauges["adbstat.nentries"])
+	assert.True(gauges["cache.A"])
+	assert.False(gauges["resqtype.A"])
+	assert.False(gauges["rcode.QrySuccess"])
+}
+
+func TestPrometheusFamilies(t *testing.T) {
+	assert := assert.New(t)
+
+	// Every reader reports its counter groups under the same families
+	plugin.StatisticsFilePath = "tests/named.stats"
+	plugin.returnMetrics = nil
+	assert.NoError(readStatisticsFile())
+	fileMetrics := len(plugin.returnMetrics)
+	fileFamilies := map[string]int{}
+	for _, group := range prometheusGroups().Groups {
+		fileFamilies[group.familyName()] = len(group.Metrics)
+	}
+
+	namedXmlStats, err := os.ReadFile("tests/named.xml")
+	assert.NoError(err)
+	assert.NoError(ReadXmlStats(namedXmlStats))
+	xmlFamilies := map[string]int{}
+	xmlMetrics := 0
+	xmlGroups := prometheusGroups()
+	for _, group := range xmlGroups.Groups {
+		xmlFamilies[group.familyName()] = len(group.Metrics)
+		xmlMetrics += len(group.Metrics)
+	}
+	for _, histogram := range xmlGroups.Histograms {
+		xmlFamilies[histogram.Name]++
+		for _, bucket := range histogram.Buckets {
+			if bucket.Count > 0 {
+				xmlMetrics++
+			}
+		}
+	}
+	// Nothing is left out of the Prometheus output
+	assert.Equal(len(plugin.returnMetrics), xmlMetrics)
+
+	namedJsonStats, err := os.ReadFile("tests/named.json")
+	assert.NoError(err)
+	assert.NoError(ReadJsonStats(namedJsonStats))
+	jsonFamilies := map[string]int{}
+	for _, group := range prometheusGroups().Groups {
+		jsonFamilies[group.familyName()] = len(group.Metrics)
+	}
+
+	assert.Greater(fileMetrics, 0)
+	for _, family := range []string{
+		"bind_incoming_requests_total", "bind_responses_total", "bind_incoming_queries_total",
+		"bind_nsstat_total", "bind_nsstat_current", "bind_zone_maintenance_total",
+		"bind_resolver_total", "bind_resolver_queries_total", "bind_cache_rrsets", "bind_cache_total",
+	} {
+		assert.Contains(fileFamilies, family)
+		assert.Contains(xmlFamilies, family)
+		assert.Contains(jsonFamilies, family)
+	}
+	for _, family := range []string{
+		"bind_memory_current_bytes", "bind_memory_bytes_total", "bind_memory_context_current_bytes",
+		"bind_socket_references", "bind_sockstats_current", "bind_traffic_request_size_bytes",
+		"bind_resolver_query_rtt_seconds",
+	} {
+		assert.Contains(xmlFamilies, family)
+	}
+
+	// The group tag becomes the family, the other tags become labels
+	metric := &Metric{
+		Name:  "QrySuccess",
+		Value: 3,
+		Tags: []*MetricTag{
+			{"view", "_default"}, {"zone", "example.com"}, {"class", "IN"}, {"type", "master"}, {"type", "rcode"},
+		},
+	}
+	groups := &PrometheusMetricGroups{}
+	groups.makePromMetric(metric)
+	assert.Equal("bind_zone_nsstat_total", groups.Groups[0].familyName())
+	assert.Equal(`view="_default",zone="example.com",class="IN",type="master",counter="QrySuccess"`,
+		promLabelsToString(groups.Groups[0].Metrics[0].Label))
+}
+
+func TestPrometheusExposition(t *testing.T) {
+	assert := assert.New(t)
+
+	readers := map[string]func() error{
+		"named.stats": func() error {
+			plugin.StatisticsFilePath = "tests/named.stats"
+			plugin.returnMetrics = nil
+			return readStatisticsFile()
+		},
+		"named.xml": func() error {
+			namedXmlStats, err := os.ReadFile("tests/named.xml")
+			if err != nil {
+				return err
+			}
+			return ReadXmlStats(namedXmlStats)
+		},
+		"named.json": func() error {
+			namedJsonStats, err := os.ReadFile("tests/named.json")
+			if err != nil {
+				return err
+			}
+			return ReadJsonStats(namedJsonStats)
+		},
+	}
+
+	for fixture, reader := range readers {
+		assert.NoError(reader(), fixture)
+		for _, metric := range plugin.returnMetrics {
+			metric.Change = &MetricChange{Delta: 1, Rate: 0.5}
+		}
+
+		output := &strings.Builder{}
+		writeMetricsPrometheus(output)
+		parser := expfmt.NewTextParser(model.LegacyValidation)
+		families, err := parser.TextToMetricFamilies(strings.NewReader(output.String()))
+		assert.NoError(err, fixture)
+
+		// Every series is unique, and written in sorted order
+		series := 0
+		seen := map[string]bool{}
+		lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+		for _, line := range lines {
+			if strings.HasPrefix(line, "#") {
+				continue
+			}
+			name := line[:strings.LastIndex(line[:strings.LastIndex(line, " ")], " ")]
+			assert.False(seen[name], "%s: duplicate series %s", fixture, name)
+			seen[name] = true
+		}
+		for _, family := range families {
+			series += len(family.Metric)
+		}
+		// Every metric shows up once, plus its delta and rate for counters,
+		// apart from the buckets that make up a histogram
+		histograms := &PrometheusMetricGroups{}
+		metrics, counters := 0, 0
+		for _, metric := range plugin.returnMetrics {
+			if histograms.addToHistogram(metric) {
+				continue
+			}
+			metrics++
+			if !metric.isGauge() {
+				counters++
+			}
+		}
+		assert.Equal(metrics+2*counters+len(histograms.Histograms), series, fixture)
+		types := []string{}
+		for _, line := range lines {
+			if name, found := strings.CutPrefix(line, "# TYPE "); found && !strings.Contains(name, "_delta ") && !strings.Contains(name, "_rate ") {
+				types = append(types, name)
+			}
+		}
+		assert.True(slices.IsSorted(types), fixture)
+		samples := slices.DeleteFunc(slices.Clone(lines), func(line string) bool {
+			return strings.HasPrefix(line, "#") || strings.Contains(line, "_delta{") || strings.Contains(line, "_rate{") ||
+				strings.Contains(line, "_bucket{") || strings.Contains(line, "_count{") || strings.Contains(line, "_sum{")
+		})
+		assert.True(slices.IsSortedFunc(samples, func(a, b string) int {
+			// Sorted by family name first, then by the labels
+			a_name, a_labels, _ := strings.Cut(a, "{")
+			b_name, b_labels, _ := strings.Cut(b, "{")
+			return cmp.Or(strings.Compare(a_name, b_name), strings.Compare(a_labels, b_labels))
+		}), fixture)
+	}
+
+	// Label values are escaped and label names sanitized
+	plugin.returnMetrics = []*Metric{{
+		Name:      `say "hi"\` + "\n",
+		Value:     1,
+		Timestamp: time.Unix(2000, 0),
+		Tags:      []*MetricTag{{"zone (#1)", "a/b!"}, {"server", "odd/group!"}},
+	}}
+	output := &strings.Builder{}
+	writeMetricsPrometheus(output)
+	assert.Contains(output.String(), `bind_odd_group__total{zone___1_="a/b!",counter="say \"hi\"\\\n"} 1 2000000`)
+	parser := expfmt.NewTextParser(model.LegacyValidation)
+	_, err := parser.TextToMetricFamilies(strings.NewReader(output.String()))
+	assert.NoError(err)
+}
+
+func TestOpenMetrics(t *testing.T) {
+	assert := assert.New(t)
+
+	namedXmlStats, err := os.ReadFile("tests/named.xml")
+	assert.NoError(err)
+	assert.NoError(ReadXmlStats(namedXmlStats))
+
+	output := &strings.Builder{}
+	writeMetricsOpenMetrics(output)
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+
+	// The server details come out as an info family
+	assert.Equal("# TYPE bind_server info", lines[0])
+	assert.Equal(`bind_server_info{version="9.16.23-RH",boot_time="2024-02-05T09:32:38Z",config_time="2024-02-05T09:32:38Z"} 1`, lines[2])
+	assert.Equal("# EOF", lines[len(lines)-1])
+
+	// Counters carry the boot time as their creation time, memory is in bytes
+	assert.Contains(lines, "# TYPE bind_memory_bytes counter")
+	assert.Contains(lines, "# UNIT bind_memory_bytes bytes")
+	assert.Contains(lines, `bind_memory_bytes_total{counter="TotalUse"} 344766456 1707464866.138`)
+	assert.Contains(lines, `bind_memory_bytes_created{counter="TotalUse"} 1707125558.714 1707464866.138`)
+	assert.Contains(lines, "# TYPE bind_memory_current_bytes gauge")
+	assert.Contains(lines, `bind_nsstat_total{counter="Requestv4"} 53471 1707464866.138`)
+
+	// Every family is described once and the only comments are metadata
+	families := map[string]bool{}
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		assert.Contains([]string{"TYPE", "UNIT", "HELP", "EOF"}, fields[1])
+		if fields[1] == "TYPE" {
+			assert.False(families[fields[2]], fields[2])
+			families[fields[2]] = true
+		}
+	}
+}
+
+func TestPrometheusHistograms(t *testing.T) {
+	assert := assert.New(t)
+
+	metric_time := time.Unix(2000, 0)
+	resstats := []*MetricTag{{"view", "_default"}, {"type", "resstats"}}
+	traffic := []*MetricTag{{"ipver", "ipv4"}, {"protocol", "udp"}, {"type", "response-size"}}
+	plugin.returnMetrics = []*Metric{
+		{Name: "QryRTT10", Value: 4, Timestamp: metric_time, Tags: resstats},
+		{Name: "QryRTT500", Value: 2, Timestamp: metric_time, Tags: resstats},
+		{Name: "QryRTT1600+", Value: 1, Timestamp: metric_time, Tags: resstats},
+		{Name: "Queryv4", Value: 9, Timestamp: metric_time, Tags: resstats},
+		{Name: "32-47", Value: 3, Timestamp: metric_time, Tags: traffic},
+		{Name: "0-15", Value: 1, Timestamp: metric_time, Tags: traffic},
+		{Name: "4096+", Value: 1, Timestamp: metric_time, Tags: traffic},
+	}
+
+	output := &strings.Builder{}
+	writeMetricsPrometheus(output)
+	assert.Contains(output.String(), `# TYPE bind_resolver_query_rtt_seconds histogram
+bind_resolver_query_rtt_seconds_bucket{view="_default",le="0.01"} 4 2000000
+bind_resolver_query_rtt_seconds_bucket{view="_default",le="0.1"} 4 2000000
+bind_resolver_query_rtt_seconds_bucket{view="_default",le="0.5"} 6 2000000
+bind_resolver_query_rtt_seconds_bucket{view="_default",le="0.8"} 6 2000000
+bind_resolver_query_rtt_seconds_bucket{view="_default",le="1.6"} 6 2000000
+bind_resolver_query_rtt_seconds_bucket{view="_default",le="+Inf"} 7 2000000
+bind_resolver_query_rtt_seconds_count{view="_default"} 7 2000000
+bind_resolver_query_rtt_seconds_sum{view="_default"} 2.22 2000000
+`)
+	assert.Contains(output.String(), `# TYPE bind_traffic_response_size_bytes histogram
+bind_traffic_response_size_bytes_bucket{ipver="ipv4",protocol="udp",le="15"} 1 2000000
+bind_traffic_response_size_bytes_bucket{ipver="ipv4",protocol="udp",le="47"} 4 2000000
+bind_traffic_response_size_bytes_bucket{ipver="ipv4",protocol="udp",le="+Inf"} 5 2000000
+bind_traffic_response_size_bytes_count{ipver="ipv4",protocol="udp"} 5 2000000
+bind_traffic_response_size_bytes_sum{ipver="ipv4",protocol="udp"} 4222 2000000
+`)
+	// The other resolver counters stay where they were
+	assert.Contains(output.String(), `bind_resolver_total{view="_default",counter="Queryv4"} 9 2000000`)
+	assert.NotContains(output.String(), "QryRTT")
+
+	parser := expfmt.NewTextParser(model.LegacyValidation)
+	_, err := parser.TextToMetricFamilies(strings.NewReader(output.String()))
+	assert.NoError(err)
+
+	// The XML UDP sizes end up in the histograms like the TCP ones
+	namedXmlStats, err := os.ReadFile("tests/named.xml")
+	assert.NoError(err)
+	assert.NoError(ReadXmlStats(namedXmlStats))
+	output.Reset()
+	writeMetricsPrometheus(output)
+	assert.Contains(output.String(), `bind_traffic_request_size_bytes_bucket{ipver="ipv4",protocol="udp",le="15"} 4 1707464866138
+bind_traffic_request_size_bytes_bucket{ipver="ipv4",protocol="udp",le="31"} 411 1707464866138
+bind_traffic_request_size_bytes_bucket{ipver="ipv4",protocol="udp",le="47"} 14066 1707464866138
+`)
+	assert.Contains(output.String(), `bind_traffic_request_size_bytes_count{ipver="ipv4",protocol="udp"} 53415 1707464866138
+`)
+	assert.NotContains(output.String(), "bind_traffic_request_size_total")
+	assert.NotContains(output.String(), "bind_traffic_response_size_total")
+}
+
+func TestExporter(t *testing.T) {
+	assert := assert.New(t)
+
+	plugin.StatisticsFormat = "file"
+	plugin.StatisticsFilePath = "tests/named.stats"
+	plugin.MetricsPath = "/metrics"
+	plugin.HealthzPath = "/healthz"
+
+	exporterServer := httptest.NewServer(newExporter(0).handler())
+	defer exporterServer.Close()
+
+	// Every request reads the statistics again, without piling up metrics
+	for range 2 {
+		resp, err := http.Get(exporterServer.URL + "/metrics")
+		assert.NoError(err)
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		assert.Equal(http.StatusOK, resp.StatusCode)
+		assert.Equal(prometheusContentType, resp.Header.Get("Content-Type"))
+		assert.Equal(1, strings.Count(string(body), `bind_nsstat_total{counter="IPv4 requests received"}`))
+	}
+
+	// OpenMetrics is served when asked for
+	req, _ := http.NewRequest("GET", exporterServer.URL+"/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text;version=1.0.0,text/plain;version=0.0.4;q=0.5")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(err)
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.Equal(openMetricsContentType, resp.Header.Get("Content-Type"))
+	assert.True(strings.HasSuffix(string(body), "# EOF\n"))
+
+	resp, err = http.Get(exporterServer.URL + "/healthz")
+	assert.NoError(err)
+	_ = resp.Body.Close()
+	assert.Equal(http.StatusOK, resp.StatusCode)
+
+	// A failed scrape shows up on both endpoints
+	plugin.StatisticsFilePath = "tests/missing.stats"
+	resp, err = http.Get(exporterServer.URL + "/metrics")
+	assert.NoError(err)
+	_ = resp.Body.Close()
+	assert.Equal(http.StatusInternalServerError, resp.StatusCode)
+	resp, err = http.Get(exporterServer.URL + "/healthz")
+	assert.NoError(err)
+	_ = resp.Body.Close()
+	assert.Equal(http.StatusServiceUnavailable, resp.StatusCode)
+
+	// With an interval the metrics come from the last scrape
+	plugin.StatisticsFilePath = "tests/named.stats"
+	cached := newExporter(time.Hour)
+	assert.NoError(cached.scrape())
+	plugin.StatisticsFilePath = "tests/missing.stats"
+	recorder := httptest.NewRecorder()
+	cached.handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(http.StatusOK, recorder.Code)
+	assert.Contains(recorder.Body.String(), "bind_nsstat_total")
+
+	// Until a failed scrape leaves them older than the interval
+	assert.Error(cached.scrape())
+	recorder = httptest.NewRecorder()
+	cached.handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(http.StatusOK, recorder.Code)
+	cached.scraped = cached.scraped.Add(-2 * time.Hour)
+	recorder = httptest.NewRecorder()
+	cached.handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(http.StatusServiceUnavailable, recorder.Code)
+	assert.Contains(recorder.Body.String(), "statistics not read since")
+}
+
+func TestReadTargets(t *testing.T) {
+	assert := assert.New(t)
+
+	namedXmlStats, _ := os.ReadFile("tests/named.xml")
+	xmlConfig := &testServer{StatsFormat: "xml", Content: namedXmlStats}
+	xmlServe := startTestServer(xmlConfig)
+	xmlServe.Start()
+	defer xmlServe.Close()
+
+	namedJsonStats, _ := os.ReadFile("tests/named.json")
+	jsonConfig := &testServer{StatsFormat: "json", Content: namedJsonStats}
+	jsonServe := startTestServer(jsonConfig)
+	jsonServe.Start()
+	defer jsonServe.Close()
+
+	// Nothing listens on the port of a closed server
+	downServe := startTestServer(&testServer{StatsFormat: "json"})
+	downAddress := downServe.Listener.Addr().String()
+	downServe.Close()
+
+	xmlAddress := net.JoinHostPort(xmlConfig.IP.String(), strconv.Itoa(xmlConfig.Port))
+	jsonAddress := net.JoinHostPort(jsonConfig.IP.String(), strconv.Itoa(jsonConfig.Port))
+	plugin.Targets = []string{
+		"xml:" + xmlAddress,
+		"json:" + jsonAddress,
+		"file:tests/named.stats",
+		"json:" + downAddress,
+		"file:tests/missing.stats",
+		"json:bind-stats.invalid:8053",
+	}
+	plugin.Concurrency = 2
+	defer func() { plugin.Targets, plugin.targets = nil, nil }()
+	state, err := checkArgs(nil)
+	assert.Equal(sensu.CheckStateOK, state)
+	assert.NoError(err)
+
+	// Every metric is tagged with the target it came from
+	assert.NoError(readStatistics())
+	instances := map[string]int{}
+	for _, metric := range plugin.returnMetrics {
+		assert.Equal("instance", metric.Tags[0][0])
+		instances[metric.Tags[0][1]]++
+	}
+	assert.Greater(instances[xmlAddress], 0)
+	assert.Greater(instances[jsonAddress], 0)
+	assert.Greater(instances["tests/named.stats"], 0)
+	assert.Len(instances, 3)
+	// A target that is down, missing or does not resolve only fails itself
+	assert.Len(plugin.targetErrors, 3)
+	assert.Contains(plugin.targetErrors[0].Error(), downAddress)
+	assert.ErrorContains(plugin.targetErrors[1], "statistics file does not exist: tests/missing.stats")
+	assert.ErrorContains(plugin.targetErrors[2], "unable to resolve statistics host bind-stats.invalid")
+
+	// Counters of the channel targets start at the boot time of their named
+	assert.Len(plugin.instanceBootTimes, 2)
+	output := &strings.Builder{}
+	writeMetricsOpenMetrics(output)
+	assert.Contains(output.String(), `bind_incoming_requests_created{instance="`+xmlAddress+`",opcode="QUERY"} 1707125558.714 `)
+	assert.Contains(output.String(), `bind_incoming_requests_created{instance="`+jsonAddress+`",opcode="QUERY"} 1707125558.714 `)
+	assert.NotContains(output.String(), `_created{instance="tests/named.stats"`)
+
+	// The ratios are worked out over every target
+	servfail := &healthRatio{Numerator: counterSelector{"rcodes", []string{"SERVFAIL"}}, Denominator: counterSelector{"rcodes", nil}}
+	assert.Greater(servfail.Denominator.sum(plugin.returnMetrics), int64(0))
+
+	// The unreadable targets fail the check without hiding the others, the
+	// host that does not resolve is the worst
+	plugin.OutputFormat = "influxdb"
+	state, err = executeCheck(nil)
+	assert.Equal(sensu.CheckStateUnknown, state)
+	assert.NoError(err)
+
+	// Nothing read at all is an error
+	plugin.targets = plugin.targets[3:]
+	assert.Error(readStatistics())
+
+	// Two targets with the same address would get the same instance tag
+	plugin.Targets = []string{"xml:" + xmlAddress, "json:" + xmlAddress}
+	state, err = checkArgs(nil)
+	assert.Equal(sensu.CheckStateUnknown, state)
+	assert.ErrorContains(err, "duplicate target instance: "+xmlAddress)
+}
+
+func TestTargetAddress(t *testing.T) {
+	assert := assert.New(t)
+
+	// Hostnames, host:port and URLs are all accepted as the address
+	tt := []struct {
+		Address  string
+		Host     string
+		Port     int
+		BasePath string
+	}{
+		{"192.0.2.1", "192.0.2.1", 8053, ""},
+		{"2001:db8::1", "2001:db8::1", 8053, ""},
+		{"[2001:db8::1]:9053", "2001:db8::1", 9053, ""},
+		{"bind-stats.internal", "bind-stats.internal", 8053, ""},
+		{"bind-stats.internal:9053", "bind-stats.internal", 9053, ""},
+		{"http://bind-stats.internal:9053/bind/", "bind-stats.internal", 9053, "/bind"},
+		{"https://127.0.0.1/", "127.0.0.1", 443, ""},
+		{"http://[::1]/x", "::1", 80, "/x"},
+		{"https://[2001:db8::1]:9443/bind", "2001:db8::1", 9443, "/bind"},
+	}
+	for _, tc := range tt {
+		target := &target{Port: 8053}
+		assert.NoError(target.setAddress(tc.Address), tc.Address)
+		assert.Equal(tc.Host, target.Host, tc.Address)
+		assert.Equal(tc.Port, target.Port, tc.Address)
+		assert.Equal(tc.BasePath, target.BasePath, tc.Address)
+	}
+	assert.Error((&target{}).setAddress("ftp://bind-stats.internal"))
+
+	// A URL target without a port is valid
+	urlTarget, err := parseTarget("json:https://127.0.0.1/")
+	assert.NoError(err)
+	assert.NoError(urlTarget.validate())
+
+	// The address family picks and orders the addresses
+	addresses, err := resolveHost(context.Background(), "192.0.2.1", "ipv4")
+	assert.NoError(err)
+	assert.Equal([]net.IP{net.ParseIP("192.0.2.1")}, addresses)
+	_, err = resolveHost(context.Background(), "192.0.2.1", "ipv6")
+	assert.Error(err)
+	addresses, err = resolveHost(context.Background(), "localhost", "prefer-ipv4")
+	assert.NoError(err)
+	assert.NotNil(addresses[0].To4())
+
+	// A host that does not resolve is UNKNOWN with a clear message
+	plugin.StatisticsFormat = "json"
+	plugin.StatisticsFilePath = ""
+	plugin.StatisticsIP = "bind-stats.invalid"
+	plugin.StatisticsPort = 8053
+	state, err := checkArgs(nil)
+	assert.Equal(sensu.CheckStateUnknown, state)
+	assert.ErrorContains(err, "unable to resolve statistics host bind-stats.invalid")
+	state, err = executeCheck(nil)
+	assert.Equal(sensu.CheckStateUnknown, state)
+	assert.ErrorContains(err, "unable to resolve statistics host bind-stats.invalid")
+
+	// A resolver that does not answer gives up after the connect timeout
+	defaultResolver := net.DefaultResolver
+	defer func() { net.DefaultResolver, plugin.ConnectTimeout = defaultResolver, 0 }()
+	net.DefaultResolver = &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}
+	plugin.ConnectTimeout = 0.2
+	started := time.Now()
+	state, err = checkArgs(nil)
+	assert.Equal(sensu.CheckStateUnknown, state)
+	assert.ErrorContains(err, "unable to resolve statistics host bind-stats.invalid")
+	assert.Less(time.Since(started), 2*time.Second)
+	net.DefaultResolver = defaultResolver
+
+	// The statistics channel can be read through its hostname
+	namedJsonStats, _ := os.ReadFile("tests/named.json")
+	jsonConfig := &testServer{StatsFormat: "json", Content: namedJsonStats}
+	jsonServe := startTestServer(jsonConfig)
+	jsonServe.Start()
+	defer jsonServe.Close()
+
+	plugin.StatisticsIP = "localhost:" + strconv.Itoa(jsonConfig.Port)
+	plugin.AddressFamily = "prefer-ipv4"
+	defer func() { plugin.AddressFamily = "" }()
+	state, err = checkArgs(nil)
+	assert.Equal(sensu.CheckStateOK, state)
+	assert.NoError(err)
+	assert.NoError(readStatistics())
+	assert.NotEmpty(plugin.returnMetrics)
+}
+
+// writeTestClientCertificate writes a self-signed client certificate and its
+// key to the directory
+func writeTestClientCertificate(t *testing.T, dir string) (*x509.Certificate, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sensu"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	certificate, _ := x509.ParseCertificate(der)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	certFile := dir + "/client.crt"
+	keyFile := dir + "/client.key"
+	assert.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return certificate, certFile, keyFile
+}
+
+func TestStatisticsChannelTLS(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+
+	namedJsonStats, _ := os.ReadFile("tests/named.json")
+	jsonConfig := &testServer{StatsFormat: "json", Content: namedJsonStats}
+	jsonServe := startTestServer(jsonConfig)
+
+	// The server wants a client certificate and checks the Authorization header
+	clientCertificate, certFile, keyFile := writeTestClientCertificate(t, dir)
+	jsonServe.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: x509.NewCertPool()}
+	jsonServe.TLS.ClientCAs.AddCert(clientCertificate)
+	wantAuthorization := ""
+	statsHandler := jsonServe.Config.Handler
+	jsonServe.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != wantAuthorization {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		statsHandler.ServeHTTP(w, r)
+	})
+	jsonServe.StartTLS()
+	defer jsonServe.Close()
+
+	caFile := dir + "/ca.crt"
+	assert.NoError(os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: jsonServe.Certificate().Raw}), 0600))
+	passwordFile := dir + "/password"
+	assert.NoError(os.WriteFile(passwordFile, []byte("s3cret\n"), 0600))
+
+	plugin.StatisticsFormat = "json"
+	plugin.StatisticsIP = "https://" + jsonServe.Listener.Addr().String()
+	defer func() {
+		plugin.CAFile, plugin.CertFile, plugin.KeyFile = "", "", ""
+		plugin.InsecureSkipVerify = false
+		plugin.Username, plugin.Password, plugin.PasswordFile = "", "", ""
+		plugin.BearerToken, plugin.BearerTokenFile = "", ""
+	}()
+
+	// The server certificate is not trusted without the CA file
+	plugin.CertFile, plugin.KeyFile = certFile, keyFile
+	assert.Error(readStatistics())
+	plugin.CAFile = caFile
+	assert.NoError(readStatistics())
+	assert.NotEmpty(plugin.returnMetrics)
+
+	// Or the certificate is not checked at all
+	plugin.CAFile = ""
+	plugin.InsecureSkipVerify = true
+	assert.NoError(readStatistics())
+
+	// The client certificate is needed
+	plugin.CertFile, plugin.KeyFile = "", ""
+	assert.Error(readStatistics())
+	plugin.CertFile, plugin.KeyFile = certFile, keyFile
+
+	// Basic authentication, with the password read from a file
+	wantAuthorization = "Basic " + base64.StdEncoding.EncodeToString([]byte("sensu:s3cret"))
+	assert.Error(readStatistics())
+	plugin.Username, plugin.PasswordFile = "sensu", passwordFile
+	assert.NoError(readStatistics())
+
+	// Bearer authentication
+	wantAuthorization = "Bearer t0ken"
+	plugin.Username, plugin.PasswordFile = "", ""
+	plugin.BearerToken = "t0ken"
+	assert.NoError(readStatistics())
+
+	// Options that do not work together are caught by checkArgs
+	plugin.Username = "sensu"
+	state, err := checkArgs(nil)
+	assert.Equal(sensu.CheckStateUnknown, state)
+	assert.Error(err)
+	plugin.Username = ""
+	plugin.KeyFile = ""
+	state, err = checkArgs(nil)
+	assert.Equal(sensu.CheckStateUnknown, state)
+	assert.Error(err)
+	plugin.KeyFile = keyFile
+	plugin.CAFile = passwordFile
+	state, err = checkArgs(nil)
+	assert.Equal(sensu.CheckStateUnknown, state)
+	assert.ErrorContains(err, "no certificates found in CA file")
+}
+
+func TestStatisticsChannelErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	namedJsonStats, _ := os.ReadFile("tests/named.json")
+	jsonConfig := &testServer{StatsFormat: "json", Content: namedJsonStats}
+	jsonServe := startTestServer(jsonConfig)
+
+	// The server misbehaves in the way the test asks for
+	var misbehave atomic.Pointer[func(w http.ResponseWriter, r *http.Request) bool]
+	var requests atomic.Int32
+	statsHandler := jsonServe.Config.Handler
+	jsonServe.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if fn := misbehave.Load(); fn != nil && (*fn)(w, r) {
+			return
+		}
+		statsHandler.ServeHTTP(w, r)
+	})
+	jsonServe.Start()
+	defer jsonServe.Close()
+
+	plugin.StatisticsFormat = "json"
+	plugin.StatisticsIP = jsonServe.Listener.Addr().String()
+	plugin.ReadTimeout = 0.2
+	plugin.RetryBackoff = 0.01
+	plugin.Retries = 2
+	defer func() {
+		plugin.ReadTimeout, plugin.RetryBackoff, plugin.Retries = 0, 0, 0
+		plugin.channelTimeout = 0
+	}()
+
+	tt := []struct {
+		Name      string
+		Misbehave func(w http.ResponseWriter, r *http.Request) bool
+		Message   string
+		State     int
+		Requests  int32
+	}{
+		{
+			"bad status",
+			func(w http.ResponseWriter, r *http.Request) bool {
+				http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+				return true
+			},
+			"statistics channel returned a bad status: 503 Service Unavailable", sensu.CheckStateCritical, 3,
+		},
+		{
+			"client error",
+			func(w http.ResponseWriter, r *http.Request) bool {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return true
+			},
+			"statistics channel returned a bad status: 403 Forbidden", sensu.CheckStateUnknown, 1,
+		},
+		{
+			"truncated body",
+			func(w http.ResponseWriter, r *http.Request) bool {
+				w.Header().Set("Content-Length", strconv.Itoa(len(namedJsonStats)))
+				_, _ = w.Write(namedJsonStats[:100])
+				return true
+			},
+			"statistics channel sent a truncated body", sensu.CheckStateUnknown, 3,
+		},
+		{
+			"timed out",
+			func(w http.ResponseWriter, r *http.Request) bool {
+				select {
+				case <-r.Context().Done():
+				case <-time.After(2 * time.Second):
+				}
+				return true
+			},
+			"statistics channel timed out", sensu.CheckStateCritical, 3,
+		},
+		{
+			"transient",
+			func(w http.ResponseWriter, r *http.Request) bool {
+				if requests.Load() == 1 {
+					http.Error(w, "Bad Gateway", http.StatusBadGateway)
+					return true
+				}
+				return false
+			},
+			"", sensu.CheckStateOK, 2,
+		},
+	}
+	for _, tc := range tt {
+		misbehave.Store(&tc.Misbehave)
+		requests.Store(0)
+		_, err := plugin.defaultTarget().read()
+		assert.Equal(tc.Requests, requests.Load(), tc.Name)
+		if tc.Message == "" {
+			assert.NoError(err, tc.Name)
+			continue
+		}
+		assert.ErrorContains(err, tc.Message, tc.Name)
+		assert.Equal(tc.State, readErrorState(err), tc.Name)
+	}
+
+	// The overall timeout stops the retries
+	misbehave.Store(&tt[3].Misbehave)
+	requests.Store(0)
+	plugin.channelTimeout = 300 * time.Millisecond
+	_, err := plugin.defaultTarget().read()
+	assert.ErrorContains(err, "statistics channel timed out")
+	assert.Equal(int32(2), requests.Load())
+	plugin.channelTimeout = 0
+
+	// Nothing listening on the port
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	plugin.StatisticsIP = closed.Addr().String()
+	_ = closed.Close()
+	_, err = plugin.defaultTarget().read()
+	assert.ErrorContains(err, "statistics channel refused the connection")
+	assert.Equal(sensu.CheckStateCritical, readErrorState(err))
+}
+
+func TestStatisticsSections(t *testing.T) {
+	assert := assert.New(t)
+
+	// Split the JSON statistics into the sections BIND serves
+	namedJsonStats, _ := os.ReadFile("tests/named.json")
+	var full map[string]any
+	assert.NoError(json.Unmarshal(namedJsonStats, &full))
+	sectionKeys := map[string][]string{
+		"server":  {"opcodes", "rcodes", "qtypes", "nsstats", "zonestats"},
+		"net":     {"sockstats", "socketmgr"},
+		"mem":     {"memory"},
+		"tasks":   {"taskmgr"},
+		"traffic": {"traffic"},
+	}
+	sections := map[string][]byte{}
+	for _, section := range statisticsSections {
+		doc := map[string]any{}
+		for _, key := range []string{"json-stats-version", "boot-time", "config-time", "current-time", "version"} {
+			doc[key] = full[key]
+		}
+		for _, key := range sectionKeys[section] {
+			doc[key] = full[key]
+		}
+		// The views have the resolver in the server section and the zones in the zones section
+		views := map[string]any{}
+		for view_name, view := range full["views"].(map[string]any) {
+			switch section {
+			case "server":
+				views[view_name] = map[string]any{"resolver": view.(map[string]any)["resolver"]}
+			case "zones":
+				views[view_name] = map[string]any{"zones": view.(map[string]any)["zones"]}
+			}
+		}
+		if len(views) > 0 {
+			doc["views"] = views
+		}
+		sections[section], _ = json.Marshal(doc)
+	}
+
+	var requested sync.Map
+	jsonServe := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested.Store(r.URL.Path, true)
+		content, found := sections[strings.TrimPrefix(r.URL.Path, "/json/v1/")]
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(content)
+	}))
+	defer jsonServe.Close()
+
+	want, err := parseJsonStats(namedJsonStats)
+	assert.NoError(err)
+	wantMetrics := []string{}
+	for _, metric := range want.Metrics {
+		wantMetrics = append(wantMetrics, metric.GraphiteTagged(""))
+	}
+	slices.Sort(wantMetrics)
+
+	plugin.StatisticsFormat = "json"
+	plugin.StatisticsIP = jsonServe.Listener.Addr().String()
+	defer func() {
+		plugin.StatisticsSections = nil
+		plugin.ParallelSections = false
+	}()
+
+	// Every section together gives the same metrics as the whole statistics
+	for _, parallel := range []bool{false, true} {
+		plugin.StatisticsSections = statisticsSections
+		plugin.ParallelSections = parallel
+		stats, err := plugin.defaultTarget().read()
+		assert.NoError(err)
+		gotMetrics := []string{}
+		for _, metric := range stats.Metrics {
+			gotMetrics = append(gotMetrics, metric.GraphiteTagged(""))
+		}
+		slices.Sort(gotMetrics)
+		assert.Equal(wantMetrics, gotMetrics)
+		assert.Equal(want.BootTime, stats.BootTime)
+		assert.Equal(want.ServerVersion, stats.ServerVersion)
+	}
+
+	// Only the chosen sections are fetched
+	requested.Clear()
+	plugin.StatisticsSections = []string{"mem"}
+	stats, err := plugin.defaultTarget().read()
+	assert.NoError(err)
+	_, found := requested.Load("/json/v1/mem")
+	assert.True(found)
+	_, found = requested.Load("/json/v1")
+	assert.False(found)
+	for _, metric := range stats.Metrics {
+		assert.Contains([]string{"memory", "context"}, metric.Tags[0][1])
+	}
+
+	// A section that can not be read fails the whole read
+	delete(sections, "traffic")
+	plugin.StatisticsSections = []string{"mem", "traffic"}
+	_, err = plugin.defaultTarget().read()
+	assert.ErrorContains(err, "traffic section: statistics channel returned a bad status: 404 Not Found")
+
+	plugin.StatisticsSections = []string{"mem", "cache"}
+	state, err := checkArgs(nil)
+	assert.Equal(sensu.CheckStateUnknown, state)
+	assert.ErrorContains(err, "invalid statistics section: cache")
+}
+
+func TestStatisticsSocket(t *testing.T) {
+	assert := assert.New(t)
+	socketPath := t.TempDir() + "/stats.sock"
+
+	for _, address := range []string{"unix:" + socketPath, "unix://" + socketPath, socketPath} {
+		target := &target{}
+		assert.NoError(target.setAddress(address), address)
+		assert.Equal(socketPath, target.Socket, address)
+		assert.Equal("", target.Host, address)
+	}
+
+	// The socket has to exist
+	plugin.StatisticsFormat = "json"
+	plugin.StatisticsIP = "unix:" + socketPath
+	state, err := checkArgs(nil)
+	assert.Equal(sensu.CheckStateUnknown, state)
+	assert.ErrorContains(err, "unable to use statistics socket")
+
+	// Both formats are read through the socket
+	for _, format := range []string{"xml", "json"} {
+		namedStats, _ := os.ReadFile("tests/named." + format)
+		statsConfig := &testServer{StatsFormat: format, Content: namedStats}
+		statsServe := startTestServer(statsConfig)
+		_ = statsServe.Listener.Close()
+		listener, err := net.Listen("unix", socketPath)
+		assert.NoError(err)
+		statsServe.Listener = listener
+		statsServe.Start()
+
+		plugin.StatisticsFormat = format
+		state, err = checkArgs(nil)
+		assert.Equal(sensu.CheckStateOK, state, format)
+		assert.NoError(err, format)
+		assert.NoError(readStatistics(), format)
+		assert.NotEmpty(plugin.returnMetrics, format)
+
+		// The same socket as a target
+		target, err := parseTarget(format + ":" + socketPath)
+		assert.NoError(err, format)
+		stats, err := target.read()
+		assert.NoError(err, format)
+		assert.Equal(len(plugin.returnMetrics), len(stats.Metrics), format)
+
+		statsServe.Close()
+	}
+}
+
+func TestStatisticsFileDumps(t *testing.T) {
+	assert := assert.New(t)
+	statsPath := t.TempDir() + "/named.stats"
+
+	// Appending the same dump again does not repeat the metrics
+	namedStats, _ := os.ReadFile("tests/named.stats")
+	single, err := parseStatisticsFile("tests/named.stats")
+	assert.NoError(err)
+	assert.NoError(os.WriteFile(statsPath, append(append([]byte{}, namedStats...), namedStats...), 0600))
+	double, err := parseStatisticsFile(statsPath)
+	assert.NoError(err)
+	assert.Equal(len(single.Metrics), len(double.Metrics))
+
+	// The newest complete dump is read, the one still being written is not
+	dumps := "+++ Statistics Dump +++ (1000)\n" +
+		"++ Incoming Requests ++\n" +
+		"                 100 QUERY\n" +
+		"--- Statistics Dump --- (1000)\n" +
+		"+++ Statistics Dump +++ (1060)\n" +
+		"++ Incoming Requests ++\n" +
+		"                 160 QUERY\n" +
+		"--- Statistics Dump --- (1060)\n" +
+		"+++ Statistics Dump +++ (1120)\n" +
+		"++ Incoming Requests ++\n" +
+		"                 999 QUERY\n"
+	assert.NoError(os.WriteFile(statsPath, []byte(dumps), 0600))
+	stats, err := parseStatisticsFile(statsPath)
+	assert.NoError(err)
+	assert.Len(stats.Metrics, 1)
+	assert.Equal(int64(160), stats.Metrics[0].Value)
+	assert.Equal(time.Unix(1060, 0), stats.Metrics[0].Timestamp)
+	assert.Nil(stats.Metrics[0].Change)
+
+	// With the deltas the change since the dump before it is reported
+	plugin.DumpDeltas = true
+	defer func() { plugin.DumpDeltas = false }()
+	stats, err = parseStatisticsFile(statsPath)
+	assert.NoError(err)
+	assert.Equal(&MetricChange{Delta: 60, Rate: 1}, stats.Metrics[0].Change)
+
+	// Nothing to read until the first dump is complete
+	assert.NoError(os.WriteFile(statsPath, []byte("+++ Statistics Dump +++ (1000)\n++ Incoming Requests ++\n"), 0600))
+	_, err = parseStatisticsFile(statsPath)
+	assert.ErrorContains(err, "no complete statistics dump")
+}
+
+func TestTriggerStatisticsDump(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	statsPath := dir + "/named.stats"
+
+	// A stand-in for rndc stats that has named append a dump a moment later
+	rndcPath := dir + "/rndc"
+	rndcScript := "#!/bin/sh\n" +
+		"(\n" +
+		"  sleep 0.2\n" +
+		"  now=$(date +%s)\n" +
+		"  printf '+++ Statistics Dump +++ (%s)\\n++ Incoming Requests ++\\n                 %s QUERY\\n--- Statistics Dump --- (%s)\\n' \"$now\" \"$2\" \"$now\" >> \"$1\"\n" +
+		") >/dev/null 2>&1 &\n"
+	assert.NoError(os.WriteFile(rndcPath, []byte(rndcScript), 0700))
+
+	plugin.StatisticsFormat = "file"
+	plugin.StatisticsFilePath = statsPath
+	plugin.RndcTimeout = 5
+	defer func() {
+		plugin.RndcCommand, plugin.RndcTimeout, plugin.FileRotation = "", 0, ""
+	}()
+
+	// Only the new dump is read, not the one already in the file
+	assert.NoError(os.WriteFile(statsPath, []byte("+++ Statistics Dump +++ (1000)\n++ Incoming Requests ++\n                 100 QUERY\n--- Statistics Dump --- (1000)\n"), 0600))
+	plugin.RndcCommand = rndcPath + " " + statsPath + " 42"
+	started := time.Now().Truncate(time.Second)
+	assert.NoError(readStatistics())
+	assert.Len(plugin.returnMetrics, 1)
+	assert.Equal(int64(42), plugin.returnMetrics[0].Value)
+	assert.False(plugin.returnMetrics[0].Timestamp.Before(started))
+
+	// The file is emptied after reading
+	plugin.FileRotation = "truncate"
+	assert.NoError(readStatistics())
+	statsInfo, err := os.Stat(statsPath)
+	assert.NoError(err)
+	assert.Equal(int64(0), statsInfo.Size())
+
+	// Or moved out of the way, and named starts a new file on the next dump
+	plugin.FileRotation = "rotate"
+	assert.NoError(readStatistics())
+	assert.NoFileExists(statsPath)
+	assert.FileExists(statsPath + ".1")
+	state, err := checkArgs(nil)
+	assert.Equal(sensu.CheckStateOK, state)
+	assert.NoError(err)
+	assert.NoError(readStatistics())
+	assert.Equal(int64(42), plugin.returnMetrics[0].Value)
+
+	// With several statistics files the command is run once for all of them
+	runsPath := dir + "/runs"
+	rndcScript = "#!/bin/sh\n" +
+		"echo run >> " + runsPath + "\n" +
+		"(\n" +
+		"  sleep 0.2\n" +
+		"  now=$(date +%s)\n" +
+		"  for f in \"$@\"; do\n" +
+		"    printf '+++ Statistics Dump +++ (%s)\\n++ Incoming Requests ++\\n                 7 QUERY\\n--- Statistics Dump --- (%s)\\n' \"$now\" \"$now\" >> \"$f\"\n" +
+		"  done\n" +
+		") >/dev/null 2>&1 &\n"
+	assert.NoError(os.WriteFile(rndcPath, []byte(rndcScript), 0700))
+	plugin.FileRotation = ""
+	plugin.targets = nil
+	for _, name := range []string{"a.stats", "b.stats"} {
+		target, err := parseTarget("file:" + dir + "/" + name)
+		assert.NoError(err)
+		plugin.targets = append(plugin.targets, target)
+	}
+	plugin.RndcCommand = rndcPath + " " + dir + "/a.stats " + dir + "/b.stats"
+	assert.NoError(readStatistics())
+	plugin.targets = nil
+	assert.Empty(plugin.targetErrors)
+	assert.Len(plugin.returnMetrics, 2)
+	runs, err := os.ReadFile(runsPath)
+	assert.NoError(err)
+	assert.Equal("run\n", string(runs))
+
+	// A command that writes no dump, or fails
+	plugin.RndcCommand = "true"
+	plugin.RndcTimeout = 0.3
+	assert.ErrorContains(readStatistics(), "no new statistics dump")
+	plugin.RndcCommand = "false"
+	assert.ErrorContains(readStatistics(), "error running rndc command")
+
+	plugin.FileRotation = "compress"
+	state, err = checkArgs(nil)
+	assert.Equal(sensu.CheckStateUnknown, state)
+	assert.ErrorContains(err, "invalid file rotation: compress")
+}
+
+func TestStaleness(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Unix(1700000000, 0)
+
+	plugin.MaxAgeWarning = 60
+	plugin.MaxAgeCritical = 120
+	defer func() {
+		plugin.MaxAgeWarning, plugin.MaxAgeCritical = 0, 0
+		plugin.OutputFormat = ""
+	}()
+
+	tt := []struct {
+		Age     time.Duration
+		State   int
+		Summary string
+	}{
+		{30 * time.Minute, sensu.CheckStateOK, "OK: statistics dump age 30.0 minutes"},
+		{90 * time.Minute, sensu.CheckStateWarning, "WARNING: statistics dump age 90.0 minutes >= 60 minutes"},
+		{150 * time.Minute, sensu.CheckStateCritical, "CRITICAL: statistics dump age 150.0 minutes >= 120 minutes"},
+	}
+	for _, tc := range tt {
+		state, summary := checkStaleness(now.Add(-tc.Age), now)
+		assert.Equal(tc.State, state)
+		assert.Equal(tc.Summary, summary)
+	}
+	// Nothing to compare for the statistics channel
+	state, summary := checkStaleness(time.Time{}, now)
+	assert.Equal(sensu.CheckStateOK, state)
+	assert.Equal("", summary)
+
+	// The dump header gives the time of the dump
+	statsPath := t.TempDir() + "/named.stats"
+	dumpTime := time.Now().Add(-90 * time.Minute).Unix()
+	assert.NoError(os.WriteFile(statsPath, []byte(fmt.Sprintf(
+		"+++ Statistics Dump +++ (%d)\n++ Incoming Requests ++\n                 100 QUERY\n--- Statistics Dump --- (%d)\n", dumpTime, dumpTime)), 0600))
+	plugin.StatisticsFormat = "file"
+	plugin.StatisticsFilePath = statsPath
+	plugin.OutputFormat = "influxdb"
+	state, err := executeCheck(nil)
+	assert.Equal(sensu.CheckStateWarning, state)
+	assert.NoError(err)
+	assert.Equal(time.Unix(dumpTime, 0), plugin.dumpTime)
+
+	// Without a header the file modification time is used
+	assert.NoError(os.WriteFile(statsPath, []byte("++ Incoming Requests ++\n                 100 QUERY\n"), 0600))
+	modTime := time.Now().Add(-3 * time.Hour).Truncate(time.Second)
+	assert.NoError(os.Chtimes(statsPath, modTime, modTime))
+	state, err = executeCheck(nil)
+	assert.Equal(sensu.CheckStateCritical, state)
+	assert.NoError(err)
+	assert.True(modTime.Equal(plugin.dumpTime))
+	assert.True(modTime.Equal(plugin.returnMetrics[0].Timestamp))
+
+	// Same for a header without a time
+	assert.NoError(os.WriteFile(statsPath, []byte("+++ Statistics Dump +++ ()\n++ Incoming Requests ++\n                 100 QUERY\n--- Statistics Dump --- ()\n"), 0600))
+	modTime = time.Now().Add(-90 * time.Minute).Truncate(time.Second)
+	assert.NoError(os.Chtimes(statsPath, modTime, modTime))
+	state, err = executeCheck(nil)
+	assert.Equal(sensu.CheckStateWarning, state)
+	assert.NoError(err)
+	assert.True(modTime.Equal(plugin.dumpTime))
+}
+
+func TestUnparsedLines(t *testing.T) {
+	assert := assert.New(t)
+	statsPath := t.TempDir() + "/named.stats"
+
+	// The fixture is fully understood
+	stats, err := parseStatisticsFile("tests/named.stats")
+	assert.NoError(err)
+	assert.Empty(stats.Unparsed)
+
+	// Characters the patterns do not allow, in a section name and in counter names
+	dumps := "+++ Statistics Dump +++ (1000)\n" +
+		"++ Name Server Statistics ++\n" +
+		"[View: _default]\n" +
+		"                 100 queries resulted in successful answer\n" +
+		"                   5 queries.with:odd,chars\n" +
+		"++ Per-Thread Statistics ++\n" +
+		"                   7 QUERY\n" +
+		"--- Statistics Dump --- (1000)\n"
+	assert.NoError(os.WriteFile(statsPath, []byte(dumps), 0600))
+	stats, err = parseStatisticsFile(statsPath)
+	assert.NoError(err)
+	assert.Equal([]*unparsedLine{
+		{statsPath, 5, "Name Server Statistics view=_default", "                   5 queries.with:odd,chars"},
+		{statsPath, 6, "Per-Thread Statistics", "++ Per-Thread Statistics ++"},
+		{statsPath, 7, "Per-Thread Statistics", "                   7 QUERY"},
+	}, stats.Unparsed)
+	// The counters of the unrecognized section do not end up in the section before it
+	assert.Len(stats.Metrics, 1)
+	assert.Equal("queries resulted in successful answer", stats.Metrics[0].Name)
+	assert.Equal(statsPath+":5 (Name Server Statistics view=_default): 5 queries.with:odd,chars", stats.Unparsed[0].String())
+
+	// Ignored unless asked for
+	state, summary := unparsedSummary(stats.Unparsed)
+	assert.Equal(sensu.CheckStateOK, state)
+	assert.Equal("", summary)
+	plugin.UnparsedLines = "report"
+	defer func() {
+		plugin.UnparsedLines = ""
+		plugin.OutputFormat = ""
+	}()
+	state, summary = unparsedSummary(stats.Unparsed)
+	assert.Equal(sensu.CheckStateOK, state)
+	assert.Equal("OK: 3 unrecognized lines in the statistics file: "+stats.Unparsed[0].String()+"; "+stats.Unparsed[1].String()+"; "+stats.Unparsed[2].String(), summary)
+
+	// Failing still reports the metrics that were read
+	plugin.UnparsedLines = "fail"
+	plugin.StatisticsFormat = "file"
+	plugin.StatisticsFilePath = statsPath
+	plugin.OutputFormat = "influxdb"
+	state, err = executeCheck(nil)
+	assert.Equal(sensu.CheckStateUnknown, state)
+	assert.NoError(err)
+	assert.Len(plugin.returnMetrics, 1)
+
+	// Only the first lines are listed
+	many := make([]*unparsedLine, 15)
+	for idx := range many {
+		many[idx] = &unparsedLine{statsPath, idx + 1, "Incoming Requests", "?"}
+	}
+	state, summary = unparsedSummary(many)
+	assert.Equal(sensu.CheckStateUnknown, state)
+	assert.True(strings.HasSuffix(summary, "; and 5 more"))
+
+	plugin.UnparsedLines = "panic"
+	state, err = checkArgs(nil)
+	assert.Equal(sensu.CheckStateUnknown, state)
+	assert.ErrorContains(err, "invalid unparsed lines handling: panic")
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	v2 "github.com/sensu/core/v2"
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+// A check can not hand metric points to Sensu itself, so the sensu output
+// format prints them as a JSON document and the same binary, run as a mutator,
+// moves them from the check output into the event for the metric handlers.
+var mutatorConfig = sensu.PluginConfig{
+	Name:     "sensu-plugins-bind-dns-checks mutator",
+	Short:    "Sensu mutator to turn the sensu output of the bind DNS check into metric points",
+	Keyspace: "sensu.io/plugins/sensu-plugins-bind-dns-checks/mutator",
+}
+
+func checkMutatorEvent(event *v2.Event) error {
+	if event.Check == nil {
+		return fmt.Errorf("event does not contain a check")
+	}
+	return nil
+}
+
+// mutateEvent adds the metric points in the check output to the event. The
+// summary lines printed before them stay behind as the check output.
+func mutateEvent(event *v2.Event) (*v2.Event, error) {
+	points := []*v2.MetricPoint{}
+	output_lines := []string{}
+	for _, line := range strings.Split(event.Check.Output, "\n") {
+		if !strings.HasPrefix(line, "{") {
+			output_lines = append(output_lines, line)
+			continue
+		}
+		metrics := &v2.Metrics{}
+		if err := json.Unmarshal([]byte(line), metrics); err != nil {
+			return nil, fmt.Errorf("invalid metric points in the check output: %s", err)
+		}
+		points = append(points, metrics.Points...)
+	}
+	if len(points) == 0 {
+		return event, nil
+	}
+
+	if event.Metrics == nil {
+		event.Metrics = &v2.Metrics{Handlers: event.Check.OutputMetricHandlers}
+	}
+	event.Metrics.Points = append(event.Metrics.Points, points...)
+	event.Check.Output = strings.Join(output_lines, "\n")
+	return event, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+func openMetricsTimestamp(timestamp time.Time) string {
+	return strconv.FormatFloat(float64(timestamp.UnixMilli())/1000, 'f', -1, 64)
+}
+
+// openMetricsInfoLabels returns the server details reported by the statistics
+// channel, the statistics file has none of them.
+func openMetricsInfoLabels() []*PromLabel {
+	labels := make([]*PromLabel, 0, 3)
+	if plugin.serverVersion != "" {
+		labels = append(labels, &PromLabel{Name: "version", Value: plugin.serverVersion})
+	}
+	if !plugin.bootTime.IsZero() {
+		labels = append(labels, &PromLabel{Name: "boot_time", Value: plugin.bootTime.UTC().Format(time.RFC3339)})
+	}
+	if !plugin.configTime.IsZero() {
+		labels = append(labels, &PromLabel{Name: "config_time", Value: plugin.configTime.UTC().Format(time.RFC3339)})
+	}
+	return labels
+}
+
+// createdTime returns the boot time of the named the labels are of, as that is
+// when every counter started from zero
+func createdTime(labels []*PromLabel) time.Time {
+	for _, label := range labels {
+		if label.Name == "instance" {
+			return plugin.instanceBootTime(label.Value)
+		}
+	}
+	return plugin.bootTime
+}
+
+// writeMetricsOpenMetrics writes the metrics in the OpenMetrics text format.
+// Counters get a _created sample from the boot time of named, as that is when
+// every counter started from zero.
+func writeMetricsOpenMetrics(w io.Writer) {
+	prom_metric_groups := prometheusGroups()
+	prom_metric_groups.sort()
+
+	if labels := openMetricsInfoLabels(); len(labels) > 0 {
+		fmt.Fprintln(w, "# TYPE bind_server info")
+		fmt.Fprintln(w, "# HELP bind_server Bind DNS server details")
+		fmt.Fprintf(w, "bind_server_info{%s} 1\n", promLabelsToString(labels))
+	}
+
+	prom_metric_groups.eachFamily(func(group *PrometheusMetricGroup) {
+		fmt.Fprintln(w, "# TYPE "+group.Name+" "+group.familyType())
+		if group.Unit != "" {
+			fmt.Fprintln(w, "# UNIT "+group.Name+" "+group.Unit)
+		}
+		fmt.Fprintln(w, "# HELP "+group.Name+" Bind DNS statistics")
+
+		for _, metric := range group.Metrics {
+			labels := promLabelsToString(metric.Label)
+			timestamp := openMetricsTimestamp(metric.Timestamp)
+			if group.Gauge {
+				fmt.Fprintf(w, "%s{%s} %d %s\n", group.Name, labels, metric.Value, timestamp)
+				continue
+			}
+			fmt.Fprintf(w, "%s_total{%s} %d %s\n", group.Name, labels, metric.Value, timestamp)
+			if created := createdTime(metric.Label); !created.IsZero() {
+				fmt.Fprintf(w, "%s_created{%s} %s %s\n", group.Name, labels, openMetricsTimestamp(created), timestamp)
+			}
+		}
+	}, func(histograms []*PrometheusHistogram) {
+		writeHistogramFamily(w, histograms, openMetricsTimestamp, true)
+	})
+
+	writeChangeFamilies(w, prom_metric_groups, openMetricsTimestamp)
+	fmt.Fprintln(w, "# EOF")
+}
+
+func OutputMetricsOpenMetrics() {
+	writeMetricsOpenMetrics(os.Stdout)
+}
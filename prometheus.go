@@ -0,0 +1,317 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type PromLabel struct {
+	Name  string
+	Value string
+}
+
+type PrometheusMetric struct {
+	Name      string
+	Label     []*PromLabel
+	Value     int64
+	Timestamp time.Time
+	Change    *MetricChange
+	Gauge     bool
+	Unit      string
+}
+
+type PrometheusMetricGroup struct {
+	Name    string
+	Gauge   bool
+	Unit    string
+	Metrics []*PrometheusMetric
+}
+
+// familyName returns the metric family name, counters get the _total suffix
+func (pmg *PrometheusMetricGroup) familyName() string {
+	if pmg.Gauge {
+		return pmg.Name
+	}
+	return pmg.Name + "_total"
+}
+
+func (pmg *PrometheusMetricGroup) familyType() string {
+	if pmg.Gauge {
+		return "gauge"
+	}
+	return "counter"
+}
+
+type PrometheusMetricGroups struct {
+	Groups     []*PrometheusMetricGroup
+	Histograms []*PrometheusHistogram
+}
+
+func (pmg *PrometheusMetricGroups) findOrAdd(pm *PrometheusMetric) int {
+	idx := -1
+	for i, group := range pmg.Groups {
+		if group.Name == pm.Name && group.Gauge == pm.Gauge {
+			idx = i
+			break
+		}
+	}
+	if idx == -1 {
+		group := &PrometheusMetricGroup{Name: pm.Name, Gauge: pm.Gauge, Unit: pm.Unit}
+		pmg.Groups = append(pmg.Groups, group)
+		idx = len(pmg.Groups) - 1
+	}
+	return idx
+}
+
+// promFamily is the metric family a counter group is reported under, and the
+// label that holds the counter name within the family.
+type promFamily struct {
+	Name  string
+	Label string
+}
+
+// promFamilies maps every counter group the readers produce onto a fixed
+// metric family, so the family names do not depend on the statistics format.
+var promFamilies = map[string]promFamily{
+	// Server wide counters
+	"opcodes":   {"bind_incoming_requests", "opcode"},
+	"qtypes":    {"bind_incoming_queries", "qtype"},
+	"rcodes":    {"bind_responses", "rcode"},
+	"nsstat":    {"bind_nsstat", "counter"},
+	"zonestats": {"bind_zone_maintenance", "counter"},
+	"sockstats": {"bind_sockstats", "counter"},
+	"resstats":  {"bind_resolver", "counter"},
+	"memory":    {"bind_memory", "counter"},
+	"context":   {"bind_memory_context", "counter"},
+	"socketmgr": {"bind_socket_references", "socket"},
+	"taskmgr":   {"bind_task_events", "task"},
+	// View counters
+	"resqtype":   {"bind_resolver_queries", "qtype"},
+	"cache":      {"bind_cache_rrsets", "rrset"},
+	"cachestats": {"bind_cache", "counter"},
+	"adbstat":    {"bind_adb", "counter"},
+	// Zone counters
+	"rcode":          {"bind_zone_nsstat", "counter"},
+	"qtype":          {"bind_zone_incoming_queries", "qtype"},
+	"gluecache":      {"bind_zone_gluecache", "counter"},
+	"dnssec-sign":    {"bind_zone_dnssec_sign", "key"},
+	"dnssec-refresh": {"bind_zone_dnssec_refresh", "key"},
+	// Traffic size histograms
+	"request-size":  {"bind_traffic_request_size", "size"},
+	"response-size": {"bind_traffic_response_size", "size"},
+}
+
+// promFamilyFor returns the family for the counter group, groups that are not
+// known get a family named after the group.
+func promFamilyFor(group string) promFamily {
+	if family, ok := promFamilies[group]; ok {
+		return family
+	}
+	return promFamily{promSanitizeName("bind_"+group, true), "counter"}
+}
+
+// promSanitizeName replaces every character that is not allowed in a metric
+// name, or a label name when colons are not allowed, with an underscore.
+func promSanitizeName(name string, allow_colon bool) string {
+	sanitized := []rune(name)
+	for idx, char := range sanitized {
+		switch {
+		case char >= 'a' && char <= 'z', char >= 'A' && char <= 'Z', char == '_':
+		case char >= '0' && char <= '9' && idx > 0:
+		case char == ':' && allow_colon:
+		default:
+			sanitized[idx] = '_'
+		}
+	}
+	return string(sanitized)
+}
+
+// promLabelEscaper escapes label values as the exposition format requires
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// promLabels turns every tag apart from the counter group tag into a label,
+// and adds the counter name under the given label name when there is one.
+func promLabels(metric *Metric, label_name string) []*PromLabel {
+	// The counter group tag is the server tag, or the type tag
+	group_idx := -1
+	for idx, tag := range metric.Tags {
+		if tag[0] == "server" {
+			group_idx = idx
+			break
+		}
+		if tag[0] == "type" {
+			group_idx = idx
+		}
+	}
+
+	label_tags := make([]*MetricTag, 0, len(metric.Tags))
+	for idx, tag := range metric.Tags {
+		if idx != group_idx {
+			label_tags = append(label_tags, &MetricTag{promSanitizeName(tag[0], false), tag[1]})
+		}
+	}
+	if label_name != "" {
+		label_tags = append(label_tags, &MetricTag{label_name, metric.Name})
+	}
+
+	labels := make([]*PromLabel, 0, len(label_tags))
+	for _, tag := range (&Metric{Tags: label_tags}).valuedTags() {
+		labels = append(labels, &PromLabel{Name: tag[0], Value: tag[1]})
+	}
+	return labels
+}
+
+// promFamilyName returns the name of the family the metric is reported under.
+// Gauges in a group that also holds counters get a _current suffix, so they
+// never share a family name with the counters of the group, and the unit of
+// the value goes at the end.
+func promFamilyName(metric *Metric, family promFamily) string {
+	name := family.Name
+	if metric.isGauge() && !slices.Contains(gaugeRegistry[metric.group()], "*") {
+		name += "_current"
+	}
+	if unit := metric.unit(); unit != "" {
+		name += "_" + unit
+	}
+	return name
+}
+
+func (pmg *PrometheusMetricGroups) makePromMetric(metric *Metric) {
+	// Bucketed distributions go into histograms instead
+	if pmg.addToHistogram(metric) {
+		return
+	}
+
+	family := promFamilyFor(metric.group())
+	prom_metric := &PrometheusMetric{
+		Name:      promFamilyName(metric, family),
+		Label:     promLabels(metric, family.Label),
+		Value:     metric.Value,
+		Timestamp: metric.Timestamp,
+		Change:    metric.Change,
+		Gauge:     metric.isGauge(),
+		Unit:      metric.unit(),
+	}
+
+	pmg_idx := pmg.findOrAdd(prom_metric)
+	pmg.Groups[pmg_idx].Metrics = append(pmg.Groups[pmg_idx].Metrics, prom_metric)
+}
+
+// prometheusGroups gathers all the metrics into their metric families
+func prometheusGroups() *PrometheusMetricGroups {
+	prom_metric_groups := &PrometheusMetricGroups{Groups: make([]*PrometheusMetricGroup, 0)}
+	for _, metric := range plugin.returnMetrics {
+		prom_metric_groups.makePromMetric(metric)
+	}
+	return prom_metric_groups
+}
+
+func (pmg *PrometheusMetricGroups) sort() {
+	sort.Slice(pmg.Groups, func(i, j int) bool {
+		return pmg.Groups[i].familyName() < pmg.Groups[j].familyName()
+	})
+	for _, group := range pmg.Groups {
+		sort.SliceStable(group.Metrics, func(i, j int) bool {
+			return promLabelsToString(group.Metrics[i].Label) < promLabelsToString(group.Metrics[j].Label)
+		})
+	}
+	sort.SliceStable(pmg.Histograms, func(i, j int) bool {
+		if pmg.Histograms[i].Name != pmg.Histograms[j].Name {
+			return pmg.Histograms[i].Name < pmg.Histograms[j].Name
+		}
+		return promLabelsToString(pmg.Histograms[i].Label) < promLabelsToString(pmg.Histograms[j].Label)
+	})
+}
+
+func promLabelsToString(labels []*PromLabel) string {
+	var label_strings []string
+	for _, label := range labels {
+		label_strings = append(label_strings, fmt.Sprintf("%s=\"%s\"", label.Name, promLabelEscaper.Replace(label.Value)))
+	}
+	return strings.Join(label_strings, ",")
+}
+
+// eachFamily calls the matching function for every metric family and every
+// histogram family, in sorted order of the family names.
+func (pmg *PrometheusMetricGroups) eachFamily(group_fn func(*PrometheusMetricGroup), histogram_fn func([]*PrometheusHistogram)) {
+	histogram_families := pmg.histogramFamilies()
+	group_idx, histogram_idx := 0, 0
+	for group_idx < len(pmg.Groups) || histogram_idx < len(histogram_families) {
+		if histogram_idx == len(histogram_families) ||
+			(group_idx < len(pmg.Groups) && pmg.Groups[group_idx].familyName() < histogram_families[histogram_idx][0].Name) {
+			group_fn(pmg.Groups[group_idx])
+			group_idx++
+			continue
+		}
+		histogram_fn(histogram_families[histogram_idx])
+		histogram_idx++
+	}
+}
+
+// writeMetricsPrometheus writes the metrics in the Prometheus text exposition
+// format, with the families and the series within them sorted.
+func writeMetricsPrometheus(w io.Writer) {
+	prom_metric_groups := prometheusGroups()
+	prom_metric_groups.sort()
+
+	prom_metric_groups.eachFamily(func(group *PrometheusMetricGroup) {
+		fmt.Fprintln(w, "# HELP "+group.familyName()+" Bind DNS statistics")
+		fmt.Fprintln(w, "# TYPE "+group.familyName()+" "+group.familyType())
+
+		for _, metric := range group.Metrics {
+			fmt.Fprintf(w, "%s{%s} %d %s\n", group.familyName(), promLabelsToString(metric.Label), metric.Value, promTimestamp(metric.Timestamp))
+		}
+	}, func(histograms []*PrometheusHistogram) {
+		writeHistogramFamily(w, histograms, promTimestamp, false)
+	})
+
+	writeChangeFamilies(w, prom_metric_groups, promTimestamp)
+}
+
+func promTimestamp(timestamp time.Time) string {
+	return strconv.FormatInt(timestamp.UnixMilli(), 10)
+}
+
+// changedMetrics returns the metrics of the group that have a change since
+// the previous check run.
+func (pmg *PrometheusMetricGroup) changedMetrics() []*PrometheusMetric {
+	changed := make([]*PrometheusMetric, 0, len(pmg.Metrics))
+	for _, metric := range pmg.Metrics {
+		if metric.Change != nil {
+			changed = append(changed, metric)
+		}
+	}
+	return changed
+}
+
+// writeChangeFamilies writes the changes of the counters since the previous
+// check run as gauge families.
+func writeChangeFamilies(w io.Writer, prom_metric_groups *PrometheusMetricGroups, timestamp func(time.Time) string) {
+	for _, group := range prom_metric_groups.Groups {
+		changed := group.changedMetrics()
+		if group.Gauge || len(changed) == 0 {
+			continue
+		}
+
+		fmt.Fprintln(w, "# HELP "+group.Name+"_delta Bind DNS statistics change since the previous check")
+		fmt.Fprintln(w, "# TYPE "+group.Name+"_delta gauge")
+		for _, metric := range changed {
+			fmt.Fprintf(w, "%s_delta{%s} %d %s\n", group.Name, promLabelsToString(metric.Label), metric.Change.Delta, timestamp(metric.Timestamp))
+		}
+		fmt.Fprintln(w, "# HELP "+group.Name+"_rate Bind DNS statistics per second rate since the previous check")
+		fmt.Fprintln(w, "# TYPE "+group.Name+"_rate gauge")
+		for _, metric := range changed {
+			fmt.Fprintf(w, "%s_rate{%s} %s %s\n", group.Name, promLabelsToString(metric.Label), strconv.FormatFloat(metric.Change.Rate, 'f', -1, 64), timestamp(metric.Timestamp))
+		}
+	}
+}
+
+func OutputMetricsPrometheus() {
+	writeMetricsPrometheus(os.Stdout)
+}
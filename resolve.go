@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+// resolveError is a statistics channel host that could not be resolved, which
+// is a problem with the check configuration or DNS rather than with BIND.
+type resolveError struct {
+	Host string
+	Err  error
+}
+
+func (re *resolveError) Error() string {
+	return fmt.Sprintf("unable to resolve statistics host %s: %s", re.Host, re.Err)
+}
+
+func (re *resolveError) Unwrap() error {
+	return re.Err
+}
+
+// resolveHost returns the addresses of the host in the order to try them in.
+// The family is any, ipv4 or ipv6 to only use that family, or prefer-ipv4 or
+// prefer-ipv6 to try that family first.
+func resolveHost(ctx context.Context, host, family string) ([]net.IP, error) {
+	var addresses []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		addresses = []net.IP{ip}
+	} else {
+		ipAddrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, &resolveError{host, err}
+		}
+		for _, ipAddr := range ipAddrs {
+			addresses = append(addresses, ipAddr.IP)
+		}
+	}
+
+	isIPv4 := func(ip net.IP) bool { return ip.To4() != nil }
+	switch family {
+	case "ipv4":
+		addresses = slices.DeleteFunc(addresses, func(ip net.IP) bool { return !isIPv4(ip) })
+	case "ipv6":
+		addresses = slices.DeleteFunc(addresses, isIPv4)
+	case "prefer-ipv4":
+		slices.SortStableFunc(addresses, func(a, b net.IP) int {
+			return boolOrder(!isIPv4(a), !isIPv4(b))
+		})
+	case "prefer-ipv6":
+		slices.SortStableFunc(addresses, func(a, b net.IP) int {
+			return boolOrder(isIPv4(a), isIPv4(b))
+		})
+	}
+
+	if len(addresses) == 0 {
+		return nil, &resolveError{host, fmt.Errorf("no %s addresses", family)}
+	}
+	return addresses, nil
+}
+
+// boolOrder sorts false before true
+func boolOrder(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return 1
+	default:
+		return -1
+	}
+}
+
+// dialAddresses returns a dial function that connects to the first of the
+// resolved addresses that accepts the connection. Every read on the
+// connection has to make progress within the read timeout.
+func dialAddresses(addresses []net.IP, port string, connectTimeout, readTimeout time.Duration) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: connectTimeout}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		var lastErr error
+		for _, address := range addresses {
+			conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(address.String(), port))
+			if err == nil {
+				return &deadlineConn{conn, readTimeout}, nil
+			}
+			lastErr = err
+		}
+		return nil, lastErr
+	}
+}
+
+// dialSocket returns a dial function that connects to the Unix socket of the
+// statistics channel, whatever address the request is for.
+func dialSocket(path string, connectTimeout, readTimeout time.Duration) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: connectTimeout}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, "unix", path)
+		if err != nil {
+			return nil, err
+		}
+		return &deadlineConn{conn, readTimeout}, nil
+	}
+}
+
+// checkResolves checks that the host resolves, giving up after the connect
+// timeout so a slow resolver can not hang the check before it starts
+func checkResolves(host string) error {
+	ctx := context.Background()
+	if plugin.ConnectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, seconds(plugin.ConnectTimeout))
+		defer cancel()
+	}
+	_, err := resolveHost(ctx, host, plugin.AddressFamily)
+	return err
+}
+
+// readErrorState is the check state for a failure to read the statistics. A
+// host that does not resolve is UNKNOWN, a statistics channel failure has its
+// own state, and anything else is CRITICAL.
+func readErrorState(err error) int {
+	var re *resolveError
+	if errors.As(err, &re) {
+		return sensu.CheckStateUnknown
+	}
+	var ce *channelError
+	if errors.As(err, &ce) {
+		return ce.State
+	}
+	return sensu.CheckStateCritical
+}
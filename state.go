@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"time"
+)
+
+// MetricChange is the change of a metric since the previous check run, as
+// loaded from the state file.
+type MetricChange struct {
+	Delta int64
+	Rate  float64
+	Reset bool
+}
+
+type stateMetric struct {
+	Value     int64     `json:"value"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// checkState is what gets stored in the state file between check runs
+type checkState struct {
+	BootTime time.Time `json:"boot_time"`
+	// InstanceBootTimes are the boot times of the targets when there are several
+	InstanceBootTimes map[string]time.Time    `json:"instance_boot_times,omitempty"`
+	Metrics           map[string]*stateMetric `json:"metrics"`
+}
+
+// bootTimeOf returns the boot time of the target with the instance name, or
+// of the only target for an empty name
+func (cs *checkState) bootTimeOf(instance string) time.Time {
+	if cs == nil {
+		return time.Time{}
+	}
+	if instance == "" {
+		return cs.BootTime
+	}
+	return cs.InstanceBootTimes[instance]
+}
+
+// key identifies a metric across check runs
+func (m *Metric) key() string {
+	tags := make([]string, 0, len(m.Tags)+1)
+	for _, tag := range m.Tags {
+		tags = append(tags, tag[0]+"="+tag[1])
+	}
+	tags = append(tags, m.Name)
+	return strings.Join(tags, ";")
+}
+
+func loadState(path string) (*checkState, error) {
+	stateData, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		// First run, there is nothing to compare against yet
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	state := &checkState{}
+	if err := json.Unmarshal(stateData, state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+func saveState(path string, state *checkState) error {
+	stateData, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a failed write never leaves a truncated state file
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, stateData, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// stateMetrics are the values of the metrics to compare later values against
+func stateMetrics(metrics []*Metric) map[string]*stateMetric {
+	values := make(map[string]*stateMetric, len(metrics))
+	for _, metric := range metrics {
+		values[metric.key()] = &stateMetric{Value: metric.Value, Timestamp: metric.Timestamp}
+	}
+	return values
+}
+
+// setChanges sets the change since the previous values on every metric that
+// has a previous value. A counter that went down, or a restart of the named it
+// came from, means the counter started over. The boot times are left out when
+// there are none to compare.
+func setChanges(metrics []*Metric, previous map[string]*stateMetric, current *checkState, earlier *checkState) {
+	for _, metric := range metrics {
+		last, ok := previous[metric.key()]
+		if !ok {
+			continue
+		}
+		instance := metric.instance()
+		bootTime := current.bootTimeOf(instance)
+		restarted := earlier != nil && !bootTime.IsZero() && !earlier.bootTimeOf(instance).Equal(bootTime)
+
+		change := &MetricChange{Delta: metric.Value - last.Value}
+		if !metric.isGauge() && (restarted || metric.Value < last.Value) {
+			// The counter started over, everything counted so far is new
+			change.Delta = metric.Value
+			change.Reset = true
+		}
+		elapsed := metric.Timestamp.Sub(last.Timestamp)
+		if change.Reset && !bootTime.IsZero() && metric.Timestamp.After(bootTime) {
+			elapsed = metric.Timestamp.Sub(bootTime)
+		}
+		if elapsed > 0 {
+			change.Rate = float64(change.Delta) / elapsed.Seconds()
+		}
+		metric.Change = change
+	}
+}
+
+// applyState sets the change since the previous check run on every metric and
+// stores the current values in the state file. A changed boot time means
+// named restarted and the counters started over, the boot time of every
+// target is kept when there are several.
+func applyState(path string, metrics []*Metric, bootTime time.Time, instanceBootTimes map[string]time.Time) error {
+	previous, err := loadState(path)
+	if err != nil {
+		return err
+	}
+
+	current := &checkState{BootTime: bootTime, InstanceBootTimes: instanceBootTimes}
+	if previous != nil {
+		setChanges(metrics, previous.Metrics, current, previous)
+	}
+
+	current.Metrics = stateMetrics(metrics)
+	return saveState(path, current)
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+var (
+	dumpStart = regexp.MustCompile(`^\+\+\+ Statistics Dump \+\+\+ \((?P<unixtime>[0-9]*)\)$`)
+	dumpEnd   = regexp.MustCompile(`^--- Statistics Dump --- \((?P<unixtime>[0-9]*)\)$`)
+)
+
+// statisticsDump is one dump that rndc stats appended to the statistics file
+type statisticsDump struct {
+	Time     time.Time
+	Lines    []string
+	Complete bool
+	// FirstLine is the line number in the file of the first of the lines
+	FirstLine int
+}
+
+// dumpTime is the time in a dump marker, zero when the marker has none so the
+// file modification time gets used instead
+func dumpTime(unixtime string) time.Time {
+	seconds, err := strconv.ParseInt(unixtime, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(seconds, 0)
+}
+
+// splitStatisticsDumps splits the statistics file into its dumps. A dump is
+// complete once its end marker is written. A file without any dump markers is
+// read as one complete dump.
+func splitStatisticsDumps(data string) []*statisticsDump {
+	lines := strings.Split(data, "\n")
+	dumps := make([]*statisticsDump, 0, 1)
+	var current *statisticsDump
+	for idx, line := range lines {
+		if matched := dumpStart.FindStringSubmatch(line); matched != nil {
+			current = &statisticsDump{Time: dumpTime(matched[1]), FirstLine: idx + 2}
+			dumps = append(dumps, current)
+		} else if dumpEnd.MatchString(line) {
+			if current != nil {
+				current.Complete = true
+			}
+			current = nil
+		} else if current != nil {
+			current.Lines = append(current.Lines, line)
+		}
+	}
+
+	if len(dumps) == 0 {
+		return []*statisticsDump{{Lines: lines, Complete: true, FirstLine: 1}}
+	}
+	return dumps
+}
+
+// newestStatisticsDumps returns the newest complete dump and the complete dump
+// before it, either can be nil. A dump that is still being written is skipped.
+func newestStatisticsDumps(dumps []*statisticsDump) (*statisticsDump, *statisticsDump) {
+	var newest, previous *statisticsDump
+	for _, dump := range dumps {
+		if !dump.Complete {
+			continue
+		}
+		if newest == nil || !dump.Time.Before(newest.Time) {
+			newest, previous = dump, newest
+		} else if previous == nil || !dump.Time.Before(previous.Time) {
+			previous = dump
+		}
+	}
+	return newest, previous
+}
+
+// unparsedLine is a line of the statistics file that the parser did not
+// recognize, which usually means BIND changed the format
+type unparsedLine struct {
+	Path    string
+	Number  int
+	Context string
+	Line    string
+}
+
+func (ul *unparsedLine) String() string {
+	return fmt.Sprintf("%s:%d (%s): %s", ul.Path, ul.Number, ul.Context, strings.TrimSpace(ul.Line))
+}
+
+// unparsedSummary reports the lines the parser did not recognize, the check
+// state is UNKNOWN when they should fail the check.
+func unparsedSummary(lines []*unparsedLine) (int, string) {
+	if len(lines) == 0 || plugin.UnparsedLines == "" || plugin.UnparsedLines == "ignore" {
+		return sensu.CheckStateOK, ""
+	}
+
+	checkState := sensu.CheckStateOK
+	if plugin.UnparsedLines == "fail" {
+		checkState = sensu.CheckStateUnknown
+	}
+	shown := make([]string, 0, maxUnparsedShown)
+	for _, line := range lines[:min(len(lines), maxUnparsedShown)] {
+		shown = append(shown, line.String())
+	}
+	if len(lines) > maxUnparsedShown {
+		shown = append(shown, fmt.Sprintf("and %d more", len(lines)-maxUnparsedShown))
+	}
+	return checkState, fmt.Sprintf("%s: %d unrecognized lines in the statistics file: %s", stateNames[checkState], len(lines), strings.Join(shown, "; "))
+}
+
+// maxUnparsedShown is how many unrecognized lines the summary lists
+const maxUnparsedShown = 10
+
+// dumpPollInterval is how often the statistics file is checked for a new dump
+const dumpPollInterval = 100 * time.Millisecond
+
+// viewName returns the name the statistics channel gives a view of the
+// statistics file, which calls the _default view "default"
+func viewName(name string) string {
+	if name == "default" {
+		return "_default"
+	}
+	return name
+}
+
+// statisticsDumpRequest is a run of the rndc command. It is run once per check
+// and every statistics file that is read waits for the dump it has named append.
+type statisticsDumpRequest struct {
+	invoked  time.Time
+	deadline time.Time
+	offsets  map[string]int64
+	err      error
+}
+
+// requestStatisticsDump runs the rndc command for the statistics files at the
+// paths, the error of the command is kept for each of them
+func requestStatisticsDump(paths []string) *statisticsDumpRequest {
+	request := &statisticsDumpRequest{offsets: make(map[string]int64, len(paths))}
+	ctx := context.Background()
+	if plugin.RndcTimeout > 0 {
+		request.deadline = time.Now().Add(seconds(plugin.RndcTimeout))
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, request.deadline)
+		defer cancel()
+	}
+
+	// Only what named appends after this counts, and the dump times are in
+	// whole seconds
+	for _, path := range paths {
+		if statsInfo, err := os.Stat(path); err == nil {
+			request.offsets[path] = statsInfo.Size()
+		}
+	}
+	request.invoked = time.Now().Truncate(time.Second)
+
+	args := strings.Fields(plugin.RndcCommand)
+	if len(args) == 0 {
+		request.err = fmt.Errorf("no rndc command specified")
+		return request
+	}
+	if output, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput(); err != nil {
+		request.err = fmt.Errorf("error running rndc command: %s: %s", err, strings.TrimSpace(string(output)))
+	}
+	return request
+}
+
+// wait waits for a dump in the statistics file at path that is complete and
+// from after the rndc command was started. The statistics file is truncated or
+// rotated afterwards when asked to, so it stops growing.
+func (request *statisticsDumpRequest) wait(path string) (*statisticsDump, *statisticsDump, error) {
+	if request.err != nil {
+		return nil, nil, request.err
+	}
+
+	// Without an rndc timeout this waits for as long as it takes
+	var expired <-chan time.Time
+	if !request.deadline.IsZero() {
+		expired = time.After(time.Until(request.deadline))
+	}
+
+	offset := request.offsets[path]
+	for {
+		dnsStats, err := os.ReadFile(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, nil, err
+		}
+		if int64(len(dnsStats)) < offset {
+			// The file was replaced in the meantime
+			offset = 0
+		}
+		fresh, _ := newestStatisticsDumps(splitStatisticsDumps(string(dnsStats[offset:])))
+		if fresh != nil && !fresh.Time.Before(request.invoked) {
+			newest, previous := newestStatisticsDumps(splitStatisticsDumps(string(dnsStats)))
+			return newest, previous, rotateStatisticsFile(path)
+		}
+
+		select {
+		case <-expired:
+			return nil, nil, fmt.Errorf("no new statistics dump in %s after running the rndc command", path)
+		case <-time.After(dumpPollInterval):
+		}
+	}
+}
+
+// rotateStatisticsFile empties the statistics file, or moves it out of the way
+// for named to start a new one on the next dump
+func rotateStatisticsFile(path string) error {
+	switch plugin.FileRotation {
+	case "truncate":
+		return os.Truncate(path, 0)
+	case "rotate":
+		return os.Rename(path, path+".1")
+	}
+	return nil
+}
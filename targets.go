@@ -0,0 +1,352 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// statistics is what a reader got out of a statistics file or channel
+type statistics struct {
+	Metrics       []*Metric
+	BootTime      time.Time
+	ConfigTime    time.Time
+	ServerVersion string
+	// DumpTime is when the statistics file dump was written
+	DumpTime time.Time
+	// Unparsed are the statistics file lines the parser did not recognize
+	Unparsed []*unparsedLine
+	// InstanceBootTimes are the boot times of the targets when several are
+	// read, by instance
+	InstanceBootTimes map[string]time.Time
+}
+
+// setStatistics makes the statistics the ones the outputs report
+func (c *Config) setStatistics(stats *statistics) {
+	c.returnMetrics = stats.Metrics
+	c.bootTime = stats.BootTime
+	c.instanceBootTimes = stats.InstanceBootTimes
+	c.configTime = stats.ConfigTime
+	c.serverVersion = stats.ServerVersion
+	c.dumpTime = stats.DumpTime
+	c.unparsedLines = stats.Unparsed
+}
+
+// mergeStatistics puts statistics read in parts together, such as the
+// sections of the statistics channel. Every part has the server details.
+func mergeStatistics(parts []*statistics) *statistics {
+	merged := &statistics{}
+	for _, part := range parts {
+		merged.Metrics = append(merged.Metrics, part.Metrics...)
+		if merged.BootTime.IsZero() {
+			merged.BootTime = part.BootTime
+		}
+		if merged.ConfigTime.IsZero() {
+			merged.ConfigTime = part.ConfigTime
+		}
+		if merged.ServerVersion == "" {
+			merged.ServerVersion = part.ServerVersion
+		}
+	}
+	return merged
+}
+
+// target is a BIND server to read the statistics of, either from the
+// statistics file or from the statistics channel.
+type target struct {
+	Name     string
+	Format   string
+	Path     string
+	Scheme   string
+	Host     string
+	Port     int
+	BasePath string
+	Socket   string
+}
+
+// defaultTarget is the target given by the statistics options
+func (c *Config) defaultTarget() *target {
+	t := &target{
+		Format: c.StatisticsFormat,
+		Path:   c.StatisticsFilePath,
+		Scheme: "http",
+		Port:   c.StatisticsPort,
+	}
+	if t.Format != "file" {
+		// A bad address shows up when the target is validated
+		_ = t.setAddress(c.StatisticsIP)
+	}
+	return t
+}
+
+// parseTarget reads a target given as format:address, where the address is
+// the statistics file path or the statistics channel address.
+func parseTarget(spec string) (*target, error) {
+	format, address, found := strings.Cut(spec, ":")
+	if !found || address == "" {
+		return nil, fmt.Errorf("invalid target %q, expected format:address", spec)
+	}
+
+	t := &target{Name: address, Format: format, Scheme: "http"}
+	switch format {
+	case "file":
+		t.Path = address
+	case "xml", "json":
+		if err := t.setAddress(address); err != nil {
+			return nil, fmt.Errorf("invalid target %q: %s", spec, err)
+		}
+	}
+	return t, nil
+}
+
+// setAddress sets the statistics channel address of the target. The address
+// is an IP address or hostname, optionally followed by a port, a URL that can
+// include a path when the channel sits behind a proxy, or a Unix socket given
+// as unix:/path or as an absolute path.
+func (t *target) setAddress(address string) error {
+	if strings.HasPrefix(address, "unix:") || strings.HasPrefix(address, "/") {
+		t.Socket = "/" + strings.TrimLeft(strings.TrimPrefix(address, "unix:"), "/")
+		return nil
+	}
+
+	if strings.Contains(address, "://") {
+		statsUrl, err := url.Parse(address)
+		if err != nil {
+			return err
+		}
+		if statsUrl.Scheme != "http" && statsUrl.Scheme != "https" {
+			return fmt.Errorf("unsupported statistics channel scheme: %s", statsUrl.Scheme)
+		}
+		t.Scheme = statsUrl.Scheme
+		t.BasePath = strings.TrimSuffix(statsUrl.Path, "/")
+		t.Host = statsUrl.Hostname()
+
+		// Without a port the URL uses the port of its scheme
+		switch {
+		case statsUrl.Port() != "":
+			t.Port, err = strconv.Atoi(statsUrl.Port())
+			return err
+		case t.Scheme == "https":
+			t.Port = 443
+		default:
+			t.Port = 80
+		}
+		return nil
+	}
+
+	// A bare IPv6 address has colons but no port
+	if net.ParseIP(address) != nil {
+		t.Host = address
+		return nil
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		// No port, which comes from the port option
+		t.Host = address
+		return nil
+	}
+	t.Host = host
+	t.Port, err = strconv.Atoi(port)
+	return err
+}
+
+// validate checks the options of the target, without looking at the
+// statistics file or channel themselves.
+func (t *target) validate() error {
+	switch t.Format {
+	case "file":
+		if t.Path == "" {
+			return fmt.Errorf("no statistics file path specified when using file format")
+		}
+	case "xml", "json":
+		if t.Socket != "" {
+			return nil
+		}
+
+		if t.Host == "" {
+			return fmt.Errorf("no statistics IP specified when using %s format", t.Format)
+		}
+
+		// Check that the port is valid
+		if t.Port < 1 || t.Port > 65535 {
+			return fmt.Errorf("invalid statistics port specified: %d", t.Port)
+		}
+	default:
+		return fmt.Errorf("invalid statistics format: %s", t.Format)
+	}
+	return nil
+}
+
+// check checks that the statistics file or socket of the target can be used.
+// It is part of reading the target, so a missing one only fails that target.
+func (t *target) check() error {
+	switch {
+	case t.Format == "file":
+		// The rndc command makes named write the file when it was rotated away
+		if plugin.RndcCommand != "" {
+			return nil
+		}
+		// Check that the file exists
+		if _, err := os.Stat(t.Path); os.IsNotExist(err) {
+			return fmt.Errorf("statistics file does not exist: %s", t.Path)
+		}
+		// Check that the file is readable
+		statsFile, err := os.Open(t.Path)
+		if err != nil {
+			return fmt.Errorf("unable to read statistics file: %s", err)
+		}
+		_ = statsFile.Close()
+	case t.Socket != "":
+		// Check that the socket exists
+		if _, err := os.Stat(t.Socket); err != nil {
+			return fmt.Errorf("unable to use statistics socket: %s", err)
+		}
+	}
+	return nil
+}
+
+// read reads the statistics of the target
+func (t *target) read() (*statistics, error) {
+	if err := t.check(); err != nil {
+		return nil, err
+	}
+
+	if t.Format == "file" {
+		stats, err := parseStatisticsFile(t.Path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading statistics file: %w", err)
+		}
+		return stats, nil
+	}
+
+	stats, err := fetchStatistics(t)
+	if err != nil {
+		return nil, fmt.Errorf("error reading statistics channel: %w", err)
+	}
+	return stats, nil
+}
+
+// instance returns the instance tag of the metric, empty when there is only
+// one target
+func (m *Metric) instance() string {
+	for _, tag := range m.Tags {
+		if tag[0] == "instance" {
+			return tag[1]
+		}
+	}
+	return ""
+}
+
+// instanceBootTime returns the boot time of the target with the instance
+// name, or of the only target for an empty name
+func (c *Config) instanceBootTime(instance string) time.Time {
+	if instance == "" {
+		return c.bootTime
+	}
+	return c.instanceBootTimes[instance]
+}
+
+// readTargets reads the targets with at most concurrency of them at a time.
+// The results and errors are in the order of the targets.
+func readTargets(targets []*target, concurrency int) ([]*statistics, []error) {
+	results := make([]*statistics, len(targets))
+	errs := make([]error, len(targets))
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range max(1, min(concurrency, len(targets))) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				results[idx], errs[idx] = targets[idx].read()
+			}
+		}()
+	}
+	for idx := range targets {
+		jobs <- idx
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results, errs
+}
+
+// statisticsFilePaths returns the statistics files of the targets, or of the
+// default target when there is no list of them
+func statisticsFilePaths(targets []*target) []string {
+	if len(targets) == 0 {
+		targets = []*target{plugin.defaultTarget()}
+	}
+	paths := make([]string, 0, len(targets))
+	for _, t := range targets {
+		if t.Format == "file" {
+			paths = append(paths, t.Path)
+		}
+	}
+	return paths
+}
+
+// readStatistics reads the statistics of the default target, or of every
+// target when there is a list of them, into plugin.returnMetrics. With a list
+// of targets the metrics get an instance tag, and the targets that could not
+// be read end up in plugin.targetErrors. It only fails when nothing was read.
+func readStatistics() error {
+	plugin.targetErrors = nil
+
+	// The rndc command is run once, for every statistics file that is read
+	if paths := statisticsFilePaths(plugin.targets); plugin.RndcCommand != "" && len(paths) > 0 {
+		plugin.dumpRequest = requestStatisticsDump(paths)
+		defer func() { plugin.dumpRequest = nil }()
+	}
+
+	if len(plugin.targets) == 0 {
+		stats, err := plugin.defaultTarget().read()
+		if err != nil {
+			return err
+		}
+		plugin.setStatistics(stats)
+		return nil
+	}
+
+	results, errs := readTargets(plugin.targets, plugin.Concurrency)
+	merged := &statistics{InstanceBootTimes: map[string]time.Time{}}
+	for idx, stats := range results {
+		if errs[idx] != nil {
+			plugin.targetErrors = append(plugin.targetErrors, fmt.Errorf("%s: %w", plugin.targets[idx].Name, errs[idx]))
+			continue
+		}
+
+		instance_tag := &MetricTag{"instance", plugin.targets[idx].Name}
+		for _, metric := range stats.Metrics {
+			metric_tags := make([]*MetricTag, 0, len(metric.Tags)+1)
+			metric_tags = append(metric_tags, instance_tag)
+			metric_tags = append(metric_tags, metric.Tags...)
+			metric.Tags = metric_tags
+		}
+		merged.Metrics = append(merged.Metrics, stats.Metrics...)
+		merged.Unparsed = append(merged.Unparsed, stats.Unparsed...)
+		if !stats.BootTime.IsZero() {
+			merged.InstanceBootTimes[plugin.targets[idx].Name] = stats.BootTime
+		}
+
+		// The oldest dump decides whether the statistics are stale
+		if !stats.DumpTime.IsZero() && (merged.DumpTime.IsZero() || stats.DumpTime.Before(merged.DumpTime)) {
+			merged.DumpTime = stats.DumpTime
+		}
+	}
+	// The server details differ between the targets, so only the boot times
+	// are kept, by instance
+	plugin.setStatistics(merged)
+
+	if len(plugin.targetErrors) == len(plugin.targets) {
+		return errors.Join(plugin.targetErrors...)
+	}
+	return nil
+}